@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/payashi/vannotate"
+)
+
+// runBatch annotates the videos under prefix in the bucket which have not been annotated yet,
+// then processes every session recorded by both cameras into <outDir>/<session>.
+// A session which fails is reported without stopping the others.
+// Annotation jobs are kept in <outDir>/jobs.json so that a rerun resumes them.
+func runBatch(ctx context.Context, prefix string) error {
+	sessions, err := client.ListSessions(ctx, bucketName, prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Found %d sessions in %s\n", len(sessions), bucketName)
+
+	var pending []string
+	for _, s := range sessions {
+		if s.Complete() {
+			pending = append(pending, pendingObjects(s)...)
+		}
+	}
+	if len(pending) > 0 {
+		jm, closeJobs, err := newJobManager(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeJobs()
+		if err := annotate(ctx, jm, pending); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, s := range sessions {
+		if !s.Complete() {
+			fmt.Printf("Skipping %s recorded by one camera\n", s.Name)
+			continue
+		}
+		fmt.Printf("Processing %s...\n", s.Name)
+		if err := processSession(ctx, s); ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions failed", failed, len(sessions))
+	}
+	return nil
+}
+
+// runWatch processes every session under prefix in the bucket as soon as both of its videos arrive,
+// polling every interval. Processed sessions are kept in <outDir>/sessions.json.
+func runWatch(ctx context.Context, prefix string, interval time.Duration) error {
+	jm, closeJobs, err := newJobManager(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeJobs()
+	notifier := &vannotate.PollNotifier{Client: client, BucketName: bucketName, Prefix: prefix, Interval: interval}
+	w, err := vannotate.NewWatcher(notifier, filepath.Join(outDir, "sessions.json"),
+		func(ctx context.Context, s vannotate.Session) error {
+			if err := annotate(ctx, jm, pendingObjects(s)); err != nil {
+				return err
+			}
+			return processSession(ctx, s)
+		})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Watching %s/%s every %v\n", bucketName, prefix, interval)
+	return w.Run(ctx)
+}
+
+// newJobManager keeps the annotation jobs in <outDir>/jobs.json.
+func newJobManager(ctx context.Context) (*vannotate.JobManager, func(), error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	vs, err := client.NewVideoService(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	jm, err := vannotate.NewJobManager(vs, filepath.Join(outDir, "jobs.json"), bucketName)
+	if err != nil {
+		vs.Close()
+		return nil, nil, err
+	}
+	return jm, func() { vs.Close() }, nil
+}
+
+// pendingObjects returns the objects of s to annotate. Chunks are left to the job manager
+// to skip, since their annotations are not listed with the session.
+func pendingObjects(s vannotate.Session) []string {
+	if cconfig.Length > 0 {
+		return s.Objects[:]
+	}
+	return s.Pending()
+}
+
+// annotate submits objs, or their chunks if cconfig is set, and waits for all of them.
+func annotate(ctx context.Context, jm *vannotate.JobManager, objs []string) error {
+	if len(objs) == 0 {
+		return nil
+	}
+	for _, obj := range objs {
+		if cconfig.Length == 0 {
+			if err := jm.Submit(ctx, obj); err != nil {
+				return err
+			}
+			continue
+		}
+		chunks, err := splitChunks(ctx, obj)
+		if err != nil {
+			return err
+		}
+		for _, c := range chunks {
+			if err := jm.SubmitAs(ctx, c.Name(obj), c.Options(bucketName, obj)...); err != nil {
+				return err
+			}
+		}
+	}
+	if err := jm.Wait(ctx); err != nil {
+		return err
+	}
+	for _, job := range jm.Status() {
+		if job.Status == vannotate.JobFailed {
+			return fmt.Errorf("annotating %s: %s", job.Object, job.Error)
+		}
+	}
+	return nil
+}
+
+// processSession identifies persons of the annotated session s into <outDir>/<session>.
+func processSession(ctx context.Context, s vannotate.Session) error {
+	dir := filepath.Join(outDir, s.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	srList1, err := getSeries(ctx, dir, s.Objects[0])
+	if err != nil {
+		return err
+	}
+	srList2, err := getSeries(ctx, dir, s.Objects[1])
+	if err != nil {
+		return err
+	}
+	srList1, srList2 = alignSession(ctx, s, srList1, srList2)
+	if err := runSession(ctx, dir, srList1, srList2); err != nil {
+		return fmt.Errorf("%s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// getSeries returns the series of objName annotated whole or in chunks by annotate.
+func getSeries(ctx context.Context, dir, objName string) ([]vannotate.Series, error) {
+	if cconfig.Length == 0 {
+		return client.GetSeries(ctx, dir, bucketName, objName)
+	}
+	chunks, err := splitChunks(ctx, objName)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetChunkedSeries(ctx, dir, bucketName, objName, chunks, cconfig)
+}
+
+// splitChunks splits <objName>.mp4 into chunks of cconfig, taking its length from its metadata
+// or from videoDuration if that cannot be read.
+func splitChunks(ctx context.Context, objName string) ([]vannotate.Chunk, error) {
+	duration := videoDuration
+	if info, err := client.ReadVideoInfo(ctx, bucketName, objName); err == nil {
+		duration = info.Duration
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if duration == 0 {
+		return nil, err
+	}
+	return vannotate.SplitChunks(duration, cconfig)
+}
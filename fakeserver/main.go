@@ -0,0 +1,34 @@
+// Serves a fake Video Intelligence API which writes its outputs under a local directory.
+//
+//	go run ./fakeserver -addr localhost:8080 -root /tmp/blobs
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/payashi/vannotate"
+	"github.com/payashi/vannotate/fakevi"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	root := flag.String("root", "blobs", "directory holding <bucket>/<object>")
+	polls := flag.Int("polls", 3, "polls before an operation finishes")
+	flag.Parse()
+
+	s := fakevi.NewServer(vannotate.DirStore{Root: *root})
+	s.Polls = *polls
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		panic(err)
+	}
+	gs := grpc.NewServer()
+	s.Register(gs)
+	fmt.Printf("Serving a fake Video Intelligence API on %s\n", lis.Addr())
+	if err := gs.Serve(lis); err != nil {
+		panic(err)
+	}
+}
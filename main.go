@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
 
 	"github.com/payashi/vannotate"
 	"github.com/payashi/vtrack"
@@ -15,9 +22,12 @@ var bucketName = "gcs-video-tracking"
 var objName1 = "2022-12-07-0300-1t"
 var objName2 = "2022-12-07-0300-2t"
 
+// Video Intelligence API and store of the videos, Google Cloud unless set by flags
+var client vannotate.Client
+
 var config = vtrack.Config{
 	K1: 1.32, K2: 0.467,
-	R1: 16. / 9., R2: 16. / 9.,
+	// R1 and R2 are taken from the videos
 	C1: *mat.NewVecDense(3, []float64{
 		0, 0, 4.028,
 	}),
@@ -33,44 +43,375 @@ var tconfig = vtrack.TuneConfig{
 	Z0:      1.7,
 }
 
+var kconfig = vtrack.KalmanConfig{
+	Dt:     0.1,
+	Accel:  1.0,
+	Noise1: 0.3,
+	Noise2: 0.5,
+	MaxGap: 10,
+}
+
+var hconfig = vtrack.HandoffConfig{
+	Dt:       0.1,
+	MaxGap:   100,
+	Window:   10,
+	PosNoise: 1.0,
+	VelNoise: 0.5,
+	Gate:     3.0,
+	MinProb:  0.5,
+}
+
+// Videos are annotated whole unless Length is set
+var cconfig = vannotate.ChunkConfig{
+	Overlap:     10 * time.Second,
+	MaxDistance: 0.05,
+}
+
+// Length of the videos to annotate in chunks if their metadata cannot be read
+var videoDuration time.Duration
+
+// Whether an identity may consist of several series of each camera
+var many bool
+
+// Whether to match series by their epipolar distance, which does not depend on Z0
+var epipolar bool
+
+// Camera model in the output directory, a pinhole camera system tuned into it if missing
+// or a homography system
+var modelFile = "camsys.json"
+
+// File of the output directory to save the homographies of a pinhole camera system into, if set
+var homographyFile string
+
+// Workers computing pairwise costs and gradients, one per CPU if not positive
+var parallelism int
+
+// Identification within windows of this many frames unless Length is 0
+var wconfig = vtrack.WindowConfig{
+	Overlap: 100,
+}
+
+// Largest difference of the creation times of two videos of a session taken as their offset.
+// Off by default, as the camera models were tuned on series that were not shifted.
+var maxOffset time.Duration
+
+var sconfig = vtrack.StitchConfig{
+	Dt:               0.1,
+	MaxGap:           30,
+	Window:           10,
+	MaxSpeed:         1.5,
+	Slack:            1.0,
+	AppearanceWeight: 1.0,
+}
+
 func main() {
-	// vannotate.DetectPerson(bucketName, objName1)
-	srList1 := vannotate.GetSeries(outDir, bucketName, objName1)
-	srList2 := vannotate.GetSeries(outDir, bucketName, objName2)
+	batch := flag.Bool("batch", false, "process every session in the bucket")
+	watch := flag.Bool("watch", false, "process sessions in the bucket as they arrive")
+	interval := flag.Duration("interval", time.Minute, "between polls in watch mode")
+	prefix := flag.String("prefix", "", "objects to process in batch or watch mode")
+	dryRun := flag.Bool("dryrun", false, "print the annotation requests of the videos instead of processing them")
+	root := flag.String("dir", "", "local directory of <bucket>/<object> to use instead of Cloud Storage")
+	flag.StringVar(&outDir, "out", outDir, "output directory")
+	flag.StringVar(&bucketName, "bucket", bucketName, "bucket of the videos")
+	flag.StringVar(&client.Endpoint, "endpoint", "", "host:port of a Video Intelligence API such as fakeserver")
+	flag.DurationVar(&cconfig.Length, "chunk", 0, "annotate videos in chunks of this length in batch or watch mode")
+	flag.DurationVar(&cconfig.Overlap, "overlap", cconfig.Overlap, "shared by consecutive chunks")
+	flag.DurationVar(&videoDuration, "duration", 0, "length of the videos to annotate in chunks if unknown")
+	flag.BoolVar(&many, "many", false, "let an identity consist of several series of each camera")
+	flag.BoolVar(&epipolar, "epipolar", false, "match series by their epipolar distance, with a pinhole camera model")
+	flag.StringVar(&modelFile, "model", modelFile, "camera model in the output directory, tuned into it if missing")
+	flag.StringVar(&homographyFile, "homography", "", "save the homographies of the pinhole camera model into this file of the output directory")
+	flag.IntVar(&parallelism, "parallelism", 0, "workers computing pairwise costs and gradients, 0 for one per CPU")
+	flag.IntVar(&wconfig.Length, "window", 0, "identify within windows of this many frames into windows.jsonl, 0 for the whole session; the series are still loaded whole")
+	flag.IntVar(&wconfig.Overlap, "windowoverlap", wconfig.Overlap, "frames shared by consecutive windows")
+	flag.DurationVar(&maxOffset, "maxoffset", maxOffset, "largest offset of the cameras of a session to align by the creation times of their videos, 0 for none")
+	flag.Parse()
+	if epipolar && (many || wconfig.Length > 0) {
+		log.Fatal("-epipolar cannot be used with -many or -window")
+	}
+	if *dryRun && (*batch || *watch) {
+		log.Fatal("-dryrun cannot be used with -batch or -watch")
+	}
+	if *root != "" {
+		client.Store = vannotate.DirStore{Root: *root}
+	}
 
-	filePath := fmt.Sprintf("%s/%s.json", outDir, "camsys")
-	cs, err := vtrack.LoadCameraSystem(filePath)
-	if err != nil {
-		fmt.Printf("Tuning the Camera System...\n")
-		sr1, sr2 := srList1[0], srList2[0]
-		plots, _ := vtrack.NewSyncedPlots(sr1, sr2)
-		tconfig.Plots = plots
+	// Interrupting cancels whatever is running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-		cs = vtrack.NewCameraSystem(config)
-		cs.Tune(tconfig)
-		// cs.Plot(fmt.Sprintf("%s/%s", outDir, "after.png"), []vannotate.Series{sr1}, []vannotate.Series{sr2})
+	if *watch {
+		if err := runWatch(ctx, *prefix, *interval); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *batch {
+		if err := runBatch(ctx, *prefix); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-		// Save on local
-		newFile, err := json.MarshalIndent(cs, "", "\t")
-		if err != nil {
-			panic(err)
+	if *dryRun {
+		for _, objName := range []string{objName1, objName2} {
+			if err := vannotate.DryRun(os.Stdout, bucketName, objName); err != nil {
+				log.Fatal(err)
+			}
 		}
-		_ = os.WriteFile(filePath, newFile, 0644)
+		return
+	}
+
+	// client.SaveToGCS(ctx, bucketName, objName1)
+	srList1, err := client.GetSeries(ctx, outDir, bucketName, objName1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srList2, err := client.GetSeries(ctx, outDir, bucketName, objName2)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := vannotate.Session{Objects: [2]string{objName1, objName2}}
+	srList1, srList2 = alignSession(ctx, s, srList1, srList2)
+	if err := runSession(ctx, outDir, srList1, srList2); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// alignSession aligns the series of both cameras of s by the creation times of their videos,
+// assuming that they started at once if those are unknown or further apart than maxOffset.
+// It leaves them as they are unless maxOffset is set.
+func alignSession(ctx context.Context, s vannotate.Session, srList1, srList2 []vannotate.Series) ([]vannotate.Series, []vannotate.Series) {
+	if maxOffset == 0 {
+		return srList1, srList2
 	}
+	offset, err := client.SessionOffset(ctx, bucketName, s)
+	if err != nil {
+		fmt.Printf("Assuming the cameras started at once: %v\n", err)
+		offset = 0
+	} else if offset > maxOffset || -offset > maxOffset {
+		fmt.Printf("Assuming the cameras started at once rather than %v apart\n", offset)
+		offset = 0
+	} else if offset != 0 {
+		fmt.Printf("Camera 2 started %v after camera 1\n", offset)
+	}
+	return vannotate.AlignSeries(srList1, srList2, offset)
+}
 
-	cs.PrintUnityParams()
-	cs.Plot(fmt.Sprintf("%s/%s.png", outDir, "iplots"), srList1, srList2)
+// runSession identifies persons in srList1 and srList2 with the camera model of outDir,
+// writing the outputs into outDir.
+func runSession(ctx context.Context, outDir string, srList1, srList2 []vannotate.Series) error {
+	// Use the flat floor unless a ground model is given
+	ground, err := vtrack.LoadGround(fmt.Sprintf("%s/%s.json", outDir, "ground"))
+	if errors.Is(err, vtrack.ErrNotFound) {
+		ground = nil
+	} else if err != nil {
+		return err
+	}
 
-	ipList := cs.Idenitfy(srList1, srList2)
-	cs.PlotJoined(fmt.Sprintf("%s/%s.png", outDir, "joined"), ipList[:3])
+	m, err := loadModel(ctx, outDir, ground, srList1, srList2)
+	if err != nil {
+		return err
+	}
+	if err := m.Plot(fmt.Sprintf("%s/%s.png", outDir, "iplots"), srList1, srList2); err != nil {
+		return err
+	}
+
+	// Link fragments of the same person within each camera
+	stList1, err := m.Stitch(0, srList1, sconfig)
+	if err != nil {
+		return err
+	}
+	stList2, err := m.Stitch(1, srList2, sconfig)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Stitched %d->%d and %d->%d series\n", len(srList1), len(stList1), len(srList2), len(stList2))
+
+	// Corrections made by hand, kept across reruns
+	cons, err := vtrack.LoadConstraints(fmt.Sprintf("%s/%s.json", outDir, "constraints"))
+	if errors.Is(err, vtrack.ErrNotFound) {
+		cons = vtrack.Constraints{}
+	} else if err != nil {
+		return err
+	}
+	if wconfig.Length > 0 {
+		err = identifyWindows(ctx, m, outDir, stList1, stList2, cons)
+	} else {
+		err = identifySession(ctx, m, outDir, stList1, stList2, cons)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Tracks leaving one camera and entering the other later
+	handoffs, err := m.Handoff(stList1, stList2, hconfig)
+	if err != nil {
+		return err
+	}
+	for _, h := range handoffs {
+		fmt.Printf("handoff: camera%d tr-%d -> camera%d tr-%d (%03d-%03d, p=%.2f)\n",
+			h.From+1, h.I, 2-h.From, h.J, h.ExitFrame, h.EntryFrame, h.Prob)
+	}
 
 	// Save on local
-	newFile, err := json.MarshalIndent(ipList, "", "\t")
+	return writeJSON(fmt.Sprintf("%s/handoffs.json", outDir), handoffs)
+}
+
+// identifySession matches srList1 and srList2 over the whole session into iplots.json of outDir.
+func identifySession(ctx context.Context, m model, outDir string, srList1, srList2 []vannotate.Series, cons vtrack.Constraints) error {
+	identify := m.Idenitfy
+	if many {
+		identify = m.IdenitfyMany
+	}
+	if epipolar {
+		em, ok := m.(interface {
+			IdenitfyEpipolar(ctx context.Context, srList1, srList2 []vannotate.Series, cons vtrack.Constraints) ([]vtrack.IPlots, error)
+		})
+		if !ok {
+			return fmt.Errorf("%s: %T cannot match by epipolar distance", modelFile, m)
+		}
+		identify = em.IdenitfyEpipolar
+	}
+	ipList, err := identify(ctx, srList1, srList2, cons)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = os.WriteFile(fmt.Sprintf("%s/iplots.json", outDir), newFile, 0644)
+	for i := range ipList {
+		ipList[i] = ipList[i].Smooth(kconfig)
+	}
+	// Matches worth checking by hand
+	for _, ip := range ipList {
+		if ip.Score >= 0.5 || ip.Forced {
+			continue
+		}
+		fmt.Printf("uncertain: %v-%v (score=%.2f, cost=%.2f %+v)", ip.Fragments1, ip.Fragments2, ip.Score, ip.Cost, ip.Breakdown)
+		if ip.RunnerUp != nil {
+			fmt.Printf(" runner-up %v-%v (cost=%.2f)", ip.RunnerUp.Fragments1, ip.RunnerUp.Fragments2, ip.RunnerUp.Cost)
+		}
+		fmt.Printf("\n")
+	}
+	njoined := len(ipList)
+	if njoined > 3 {
+		njoined = 3
+	}
+	if err := m.PlotJoined(fmt.Sprintf("%s/%s.png", outDir, "joined"), ipList[:njoined]); err != nil {
+		return err
+	}
+	return writeJSON(fmt.Sprintf("%s/iplots.json", outDir), ipList)
+}
+
+// identifyWindows matches srList1 and srList2 within the windows of wconfig,
+// appending each window to windows.jsonl of outDir as soon as it is done.
+func identifyWindows(ctx context.Context, m model, outDir string, srList1, srList2 []vannotate.Series, cons vtrack.Constraints) error {
+	f, err := os.Create(fmt.Sprintf("%s/windows.jsonl", outDir))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	var werr error
+	err = m.IdenitfyWindows(ctx, srList1, srList2, wconfig, cons, func(w vtrack.WindowResult) {
+		if werr != nil {
+			return
+		}
+		for i := range w.IPlots {
+			w.IPlots[i] = w.IPlots[i].Smooth(kconfig)
+		}
+		fmt.Printf("Window %d-%d: %d matches\n", w.Start, w.End, len(w.IPlots))
+		werr = enc.Encode(w)
+	})
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	return f.Close()
+}
+
+func writeJSON(filePath string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, b, 0644)
+}
+
+// Camera model identifying persons, a tuned CameraSystem or a HomographySystem
+type model interface {
+	vtrack.CameraModel
+	Plot(filePath string, srLists ...[]vannotate.Series) error
+	PlotJoined(filePath string, iplots []vtrack.IPlots) error
+	Stitch(cami int, srList []vannotate.Series, sconfig vtrack.StitchConfig) ([]vannotate.Series, error)
+	Idenitfy(ctx context.Context, srList1, srList2 []vannotate.Series, cons vtrack.Constraints) ([]vtrack.IPlots, error)
+	IdenitfyMany(ctx context.Context, srList1, srList2 []vannotate.Series, cons vtrack.Constraints) ([]vtrack.IPlots, error)
+	IdenitfyWindows(ctx context.Context, srList1, srList2 []vannotate.Series, wconfig vtrack.WindowConfig, cons vtrack.Constraints, emit func(vtrack.WindowResult)) error
+	Handoff(srList1, srList2 []vannotate.Series, hconfig vtrack.HandoffConfig) ([]vtrack.Handoff, error)
+	SetParallelism(n int)
+}
+
+var (
+	_ model = (*vtrack.CameraSystem)(nil)
+	_ model = (*vtrack.HomographySystem)(nil)
+)
+
+// loadModel loads the camera model <outDir>/<modelFile>, or tunes a camera system
+// on srList1 and srList2 and saves it there if it does not exist.
+func loadModel(ctx context.Context, outDir string, ground vtrack.Ground, srList1, srList2 []vannotate.Series) (model, error) {
+	filePath := filepath.Join(outDir, modelFile)
+	cm, err := vtrack.LoadCameraModel(filePath)
+	if errors.Is(err, vtrack.ErrNotFound) {
+		fmt.Printf("Tuning the Camera System...\n")
+		cfg := config
+		cfg.R1, cfg.R2 = vannotate.AspectOf(srList1), vannotate.AspectOf(srList2)
+		cs := vtrack.NewCameraSystem(cfg)
+		cs.SetGround(ground)
+		cs.SetParallelism(parallelism)
+		plots, best, err := cs.BestSyncedPlots(srList1, srList2, tconfig.Z0)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Calibrating with tr-%d and tr-%d (score: %.3f)\n", best.I, best.J, best.Score)
+		tconfig.Plots = plots
+
+		if err := cs.Tune(ctx, tconfig); err != nil {
+			return nil, err
+		}
+		n1, n2 := cs.InvalidFrames()
+		fmt.Printf("Tune: %d/%d frames dropped in camera1, %d/%d in camera2\n", n1, plots.Len(), n2, plots.Len())
+		if e, err := cs.EpipolarError(srList1[best.I], srList2[best.J]); err == nil {
+			fmt.Printf("Epipolar error after tuning: %.4f\n", e)
+		}
+
+		// Save on local
+		if err := writeJSON(filePath, cs); err != nil {
+			return nil, err
+		}
+		cm = cs
+	} else if err != nil {
+		return nil, err
+	}
+
+	if cs, ok := cm.(*vtrack.CameraSystem); ok {
+		cs.SetGround(ground)
+		cs.PrintUnityParams()
+		if homographyFile != "" {
+			hs, err := cs.HomographySystem()
+			if err != nil {
+				return nil, err
+			}
+			// Save on local, to be used with -model
+			if err := writeJSON(filepath.Join(outDir, homographyFile), hs); err != nil {
+				return nil, err
+			}
+			fmt.Printf("Saved the homographies into %s\n", homographyFile)
+		}
+	}
+	m, ok := cm.(model)
+	if !ok {
+		return nil, fmt.Errorf("%s: %T cannot identify persons", filePath, cm)
 	}
+	m.SetParallelism(parallelism)
+	return m, nil
 }
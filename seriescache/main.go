@@ -0,0 +1,103 @@
+// Lists, verifies and purges the series cached by vannotate.GetSeries.
+//
+//	go run ./seriescache -out out list
+//	go run ./seriescache -out out verify [-source]
+//	go run ./seriescache -out out purge [-object 2022-12-07-0300-1t] [-source] [-all]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/payashi/vannotate"
+)
+
+func main() {
+	outDir := flag.String("out", "out", "output directory holding cache/")
+	source := flag.Bool("source", false, "also check entries against the annotations in the bucket")
+	object := flag.String("object", "", "only purge entries of this object")
+	all := flag.Bool("all", false, "purge every entry, not only broken, stale or superseded ones")
+	root := flag.String("dir", "", "local directory of <bucket>/<object> to use instead of Cloud Storage")
+	flag.Parse()
+	client := vannotate.Client{}
+	if *root != "" {
+		client.Store = vannotate.DirStore{Root: *root}
+	}
+
+	entries, err := vannotate.ListCache(*outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	// Why an entry should go, or "" if it should stay
+	check := func(i int) string {
+		e := entries[i]
+		if err := e.Verify(*outDir); err != nil {
+			return err.Error()
+		}
+		if *source {
+			if ok, err := client.IsCurrent(ctx, e); err != nil {
+				return err.Error()
+			} else if !ok {
+				return "stale"
+			}
+		}
+		// Entries of other requests or conversions of the object may still be in use
+		for _, later := range entries[i+1:] {
+			if later.Object == e.Object && later.Request == e.Request && later.Conversion == e.Conversion {
+				return "superseded"
+			}
+		}
+		return ""
+	}
+
+	switch flag.Arg(0) {
+	case "list":
+		for _, e := range entries {
+			fmt.Printf("%s %s/%s %d series of %d frames (source %s, request %s, conversion %+v, %s)\n",
+				e.Key, e.Bucket, e.Object, e.Series, e.Frames, e.Source, e.Request, e.Conversion, e.Created.Format("2006-01-02 15:04"))
+		}
+	case "verify":
+		bad := 0
+		for i, e := range entries {
+			if why := check(i); why != "" && why != "superseded" {
+				fmt.Printf("%s %s: %s\n", e.Key, e.Object, why)
+				bad++
+			}
+		}
+		fmt.Printf("%d of %d entries bad\n", bad, len(entries))
+		if bad > 0 {
+			os.Exit(1)
+		}
+	case "purge":
+		reasons := make(map[string]string)
+		for i, e := range entries {
+			if *object != "" && e.Object != *object {
+				continue
+			}
+			if why := check(i); why != "" {
+				reasons[e.Key] = why
+			} else if *all {
+				reasons[e.Key] = "all"
+			}
+		}
+		n, err := vannotate.PurgeCache(*outDir, func(e vannotate.CacheEntry) bool {
+			why, ok := reasons[e.Key]
+			if ok {
+				fmt.Printf("Purging %s %s: %s\n", e.Key, e.Object, why)
+			}
+			return ok
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Purged %d of %d entries\n", n, len(entries))
+	default:
+		fmt.Fprintf(os.Stderr, "usage: seriescache [flags] list|verify|purge\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,54 @@
+package vannotate
+
+import "math"
+
+// Distribution over the values of each attribute, e.g. {"UpperClothingColor": {"black": 0.7, "gray": 0.2}}
+type Appearance map[string]map[string]float64
+
+// Appearance builds the descriptor of a person from attrs.
+// Values of each attribute are normalized to sum up to 1.
+func (attrs Attributes) Appearance() Appearance {
+	ret := make(Appearance)
+	for _, attr := range attrs {
+		if attr.Conf <= 0 {
+			continue
+		}
+		if ret[attr.Name] == nil {
+			ret[attr.Name] = make(map[string]float64)
+		}
+		ret[attr.Name][attr.Value] += float64(attr.Conf)
+	}
+	for _, values := range ret {
+		sum := .0
+		for _, v := range values {
+			sum += v
+		}
+		for k := range values {
+			values[k] /= sum
+		}
+	}
+	return ret
+}
+
+// Distance returns the mean Hellinger distance between the distributions of
+// the attributes both descriptors have, in [0, 1].
+// ok is false if they have no attribute in common.
+func (a Appearance) Distance(b Appearance) (dist float64, ok bool) {
+	n := 0
+	for name, pa := range a {
+		pb, found := b[name]
+		if !found {
+			continue
+		}
+		bc := .0 // Bhattacharyya coefficient
+		for value, x := range pa {
+			bc += math.Sqrt(x * pb[value])
+		}
+		dist += math.Sqrt(math.Max(0, 1-bc))
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return dist / float64(n), true
+}
@@ -0,0 +1,208 @@
+package vannotate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client reaches the Video Intelligence API and the store of the videos.
+// The zero value uses Google Cloud for both.
+type Client struct {
+	// Endpoint of the Video Intelligence API as host:port, e.g. that of a fakevi server.
+	// It is reached without credentials unless it is empty, which means Google Cloud.
+	Endpoint string
+	// Store holds the videos and annotation outputs, nil for Google Cloud Storage.
+	Store BlobStore
+}
+
+// BlobStore holds objects by bucket and name
+type BlobStore interface {
+	NewReader(ctx context.Context, bucketName, objName string) (io.ReadCloser, error)
+	// NewRangeReader reads length bytes from offset, or up to the end if length is negative.
+	NewRangeReader(ctx context.Context, bucketName, objName string, offset, length int64) (io.ReadCloser, error)
+	NewWriter(ctx context.Context, bucketName, objName string) (io.WriteCloser, error)
+	// List returns the names starting with prefix in lexical order.
+	List(ctx context.Context, bucketName, prefix string) ([]string, error)
+	// Version returns a generation or hash which changes whenever the object does.
+	Version(ctx context.Context, bucketName, objName string) (string, error)
+	// Attrs returns the size and the last modification of an object without reading it.
+	Attrs(ctx context.Context, bucketName, objName string) (ObjectAttrs, error)
+}
+
+type ObjectAttrs struct {
+	Size    int64
+	Updated time.Time
+}
+
+// Options returns the options of API clients reaching c.Endpoint.
+func (c Client) Options() []option.ClientOption {
+	if c.Endpoint == "" {
+		return nil
+	}
+	return []option.ClientOption{
+		option.WithEndpoint(c.Endpoint),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+}
+
+// store returns c.Store, or a client of Google Cloud Storage which close releases.
+func (c Client) store(ctx context.Context) (BlobStore, func(), error) {
+	if c.Store != nil {
+		return c.Store, func() {}, nil
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return GCSStore{client}, func() { client.Close() }, nil
+}
+
+type GCSStore struct {
+	Client *storage.Client
+}
+
+func (gs GCSStore) NewReader(ctx context.Context, bucketName, objName string) (io.ReadCloser, error) {
+	return gs.Client.Bucket(bucketName).Object(objName).NewReader(ctx)
+}
+
+func (gs GCSStore) NewRangeReader(ctx context.Context, bucketName, objName string, offset, length int64) (io.ReadCloser, error) {
+	return gs.Client.Bucket(bucketName).Object(objName).NewRangeReader(ctx, offset, length)
+}
+
+func (gs GCSStore) NewWriter(ctx context.Context, bucketName, objName string) (io.WriteCloser, error) {
+	return gs.Client.Bucket(bucketName).Object(objName).NewWriter(ctx), nil
+}
+
+func (gs GCSStore) List(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	var ret []string
+	it := gs.Client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, attrs.Name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (gs GCSStore) Version(ctx context.Context, bucketName, objName string) (string, error) {
+	attrs, err := gs.Client.Bucket(bucketName).Object(objName).Attrs(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gen-%d", attrs.Generation), nil
+}
+
+func (gs GCSStore) Attrs(ctx context.Context, bucketName, objName string) (ObjectAttrs, error) {
+	attrs, err := gs.Client.Bucket(bucketName).Object(objName).Attrs(ctx)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	return ObjectAttrs{Size: attrs.Size, Updated: attrs.Updated}, nil
+}
+
+// DirStore keeps objects in local files at <Root>/<bucket>/<name>
+type DirStore struct {
+	Root string
+}
+
+func (ds DirStore) path(bucketName, objName string) string {
+	return filepath.Join(ds.Root, bucketName, filepath.FromSlash(objName))
+}
+
+func (ds DirStore) NewReader(ctx context.Context, bucketName, objName string) (io.ReadCloser, error) {
+	return os.Open(ds.path(bucketName, objName))
+}
+
+func (ds DirStore) NewRangeReader(ctx context.Context, bucketName, objName string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(ds.path(bucketName, objName))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (ds DirStore) NewWriter(ctx context.Context, bucketName, objName string) (io.WriteCloser, error) {
+	path := ds.path(bucketName, objName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+func (ds DirStore) List(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	root := filepath.Join(ds.Root, bucketName)
+	var ret []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if name := filepath.ToSlash(rel); err == nil && strings.HasPrefix(name, prefix) {
+			ret = append(ret, name)
+		}
+		return err
+	})
+	sort.Strings(ret)
+	return ret, err
+}
+
+func (ds DirStore) Version(ctx context.Context, bucketName, objName string) (string, error) {
+	f, err := os.Open(ds.path(bucketName, objName))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256-%x", h.Sum(nil)), nil
+}
+
+func (ds DirStore) Attrs(ctx context.Context, bucketName, objName string) (ObjectAttrs, error) {
+	fi, err := os.Stat(ds.path(bucketName, objName))
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	return ObjectAttrs{Size: fi.Size(), Updated: fi.ModTime()}, nil
+}
+
+// ParseGsUri splits gs://<bucket>/<name>
+func ParseGsUri(uri string) (string, string, error) {
+	rest := strings.TrimPrefix(uri, "gs://")
+	bucketName, objName, ok := strings.Cut(rest, "/")
+	if rest == uri || !ok || bucketName == "" || objName == "" {
+		return "", "", fmt.Errorf("invalid gs uri %q", uri)
+	}
+	return bucketName, objName, nil
+}
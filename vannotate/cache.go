@@ -0,0 +1,280 @@
+package vannotate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/payashi/vannotate/mp4"
+	"google.golang.org/protobuf/proto"
+)
+
+// Conversion of annotations into series, which is part of the key of cached series
+type ConversionConfig struct {
+	MaxDur  int `json:"maxdur"`  // frames of every series of a video of unknown duration
+	Version int `json:"version"` // of loadFromGCS, bumped whenever its output changes
+}
+
+var Conversion = ConversionConfig{MaxDur: 601, Version: 2}
+
+// Series cached in <outDir>/cache/<key>.json, described by the sidecar <key>.meta.json
+type CacheEntry struct {
+	Key        string           `json:"key"`
+	Bucket     string           `json:"bucket"`
+	Object     string           `json:"object"`
+	Source     string           `json:"source"`  // version of <object>.json in the bucket
+	Request    string           `json:"request"` // hash of the annotation request
+	Conversion ConversionConfig `json:"conversion"`
+	Frames     int              `json:"frames"` // of every series
+	Aspect     float64          `json:"aspect"` // of the video, 0 if unknown
+	Series     int              `json:"series"`
+	SHA256     string           `json:"sha256"` // of the series file
+	Created    time.Time        `json:"created"`
+}
+
+func cacheDir(outDir string) string {
+	return filepath.Join(outDir, "cache")
+}
+
+func (e CacheEntry) path(outDir string) string {
+	return filepath.Join(cacheDir(outDir), e.Key+".json")
+}
+
+func (e CacheEntry) metaPath(outDir string) string {
+	return filepath.Join(cacheDir(outDir), e.Key+".meta.json")
+}
+
+func hash(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// newCacheEntry keys the series of objName on the current annotation in the bucket,
+// the request annotating it with opts, the length and aspect ratio of its video and Conversion.
+// A video whose metadata cannot be read is assumed to last Conversion.MaxDur frames.
+func newCacheEntry(ctx context.Context, store BlobStore, bucketName, objName string, opts ...RequestOption) (CacheEntry, error) {
+	source, err := store.Version(ctx, bucketName, objName+".json")
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	jr := newJobRequest(bucketName, objName, opts...)
+	req, err := proto.MarshalOptions{Deterministic: true}.Marshal(jr)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	e := CacheEntry{
+		Bucket:     bucketName,
+		Object:     objName,
+		Source:     source,
+		Request:    hash(req)[:16],
+		Conversion: Conversion,
+	}
+
+	var info mp4.Info
+	videoBucket, videoName, err := ParseGsUri(jr.InputUri)
+	if err == nil {
+		info, err = readVideoInfo(ctx, store, videoBucket, videoName)
+	}
+	if ctx.Err() != nil {
+		return CacheEntry{}, ctx.Err()
+	} else if err != nil {
+		fmt.Printf("Assuming the default length and aspect ratio of %s: %v\n", objName, err)
+	}
+	_, e.Frames = segmentFrames(jr, info.Duration)
+	e.Aspect = info.Aspect()
+
+	b, err := json.Marshal([]interface{}{e.Bucket, e.Object, e.Source, e.Request, e.Conversion, e.Frames, e.Aspect})
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	e.Key = hash(b)[:32]
+	return e, nil
+}
+
+// load reads the cached series after checking them against the sidecar.
+func (e CacheEntry) load(outDir string) ([]Series, error) {
+	b, err := ioutil.ReadFile(e.path(outDir))
+	if err != nil {
+		return nil, err
+	}
+	if sum := hash(b); sum != e.SHA256 {
+		return nil, fmt.Errorf("%s: sha256 %s, want %s", e.path(outDir), sum, e.SHA256)
+	}
+	var ret []Series
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.path(outDir), err)
+	}
+	return ret, nil
+}
+
+// save writes series and then the sidecar, so that an entry without one is incomplete.
+func (e CacheEntry) save(outDir string, series []Series) (CacheEntry, error) {
+	if err := os.MkdirAll(cacheDir(outDir), 0755); err != nil {
+		return e, err
+	}
+	b, err := json.MarshalIndent(series, "", "\t")
+	if err != nil {
+		return e, err
+	}
+	if err := ioutil.WriteFile(e.path(outDir), b, 0644); err != nil {
+		return e, err
+	}
+	e.Series, e.SHA256, e.Created = len(series), hash(b), time.Now()
+	return e, writeState(e.metaPath(outDir), e)
+}
+
+// Verify checks that the series of e are intact.
+func (e CacheEntry) Verify(outDir string) error {
+	_, err := e.load(outDir)
+	return err
+}
+
+// IsCurrent checks whether e was made from the current annotation in the bucket with Conversion.
+func (c Client) IsCurrent(ctx context.Context, e CacheEntry) (bool, error) {
+	store, closeStore, err := c.store(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeStore()
+	source, err := store.Version(ctx, e.Bucket, e.Object+".json")
+	if err != nil {
+		return false, err
+	}
+	return source == e.Source && e.Conversion == Conversion, nil
+}
+
+// ListCache returns the entries cached in outDir by object and then from the oldest.
+func ListCache(outDir string) ([]CacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(cacheDir(outDir), "*.meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]CacheEntry, 0, len(paths))
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var e CacheEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if e.Key != strings.TrimSuffix(filepath.Base(path), ".meta.json") {
+			return nil, fmt.Errorf("%s: key %s does not match", path, e.Key)
+		}
+		ret = append(ret, e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Object != ret[j].Object {
+			return ret[i].Object < ret[j].Object
+		}
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// PurgeCache removes the entries in outDir for which purge returns true and returns how many.
+func PurgeCache(outDir string, purge func(e CacheEntry) bool) (int, error) {
+	entries, err := ListCache(outDir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		if !purge(e) {
+			continue
+		}
+		// The sidecar goes first so that a half-removed entry is never used
+		if err := os.Remove(e.metaPath(outDir)); err != nil {
+			return n, err
+		}
+		if err := os.Remove(e.path(outDir)); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// GetSeries returns the series of objName annotated with opts, fetching them from the bucket
+// unless they are cached in outDir. When the bucket cannot be reached, it falls back to the latest
+// series cached for objName, or to <outDir>/<objName>.json written before the cache existed.
+// Series of a request limited to segments start at the first frame of its segments,
+// and otherwise last as long as the video.
+// It fails with ErrNotFound if the annotation does not exist, and with ErrInvalidAnnotation
+// if it cannot be converted.
+func (c Client) GetSeries(ctx context.Context, outDir, bucketName, objName string, opts ...RequestOption) ([]Series, error) {
+	store, closeStore, err := c.store(ctx)
+	if err != nil {
+		return getStaleSeries(outDir, objName, err)
+	}
+	defer closeStore()
+	e, err := newCacheEntry(ctx, store, bucketName, objName, opts...)
+	if err = notFound(objName+".json", err); errors.Is(err, ErrNotFound) || ctx.Err() != nil {
+		return nil, err
+	} else if err != nil {
+		return getStaleSeries(outDir, objName, err)
+	}
+
+	if meta, err := ioutil.ReadFile(e.metaPath(outDir)); err == nil {
+		var cached CacheEntry
+		if err = json.Unmarshal(meta, &cached); err == nil {
+			var series []Series
+			if series, err = cached.load(outDir); err == nil {
+				return series, nil
+			}
+		}
+		fmt.Printf("Refetching %s: %v\n", objName, err)
+	}
+
+	fmt.Printf("Fetching %s...\n", objName)
+	first, _ := segmentFrames(newJobRequest(bucketName, objName, opts...), 0)
+	series, err := loadFromGCS(ctx, store, bucketName, objName, first, e.Frames)
+	if err != nil {
+		return nil, err
+	}
+	for i := range series {
+		series[i].Aspect = e.Aspect
+	}
+	if err := PlotScreen(outDir, objName, series); err != nil {
+		return nil, err
+	}
+	if _, err := e.save(outDir, series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+func getStaleSeries(outDir, objName string, cause error) ([]Series, error) {
+	entries, err := ListCache(outDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Object != objName {
+			continue
+		}
+		if series, err := entries[i].load(outDir); err == nil {
+			fmt.Printf("Using cached series of %s unchecked: %v\n", objName, cause)
+			return series, nil
+		}
+	}
+
+	filePath := fmt.Sprintf("%s/%s.json", outDir, objName)
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("no series of %s: %w", objName, cause)
+	}
+	var ret []Series
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return ret, nil
+}
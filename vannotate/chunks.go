@@ -0,0 +1,247 @@
+package vannotate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+)
+
+// Interval of the frames of series. It does not follow the frame rate of the video,
+// as the configs of vtrack take 10 frames per second whatever the camera.
+const frameDuration = 100 * time.Millisecond
+
+func frameOf(d time.Duration) int {
+	return int(d / frameDuration)
+}
+
+// segmentFrames returns the first frame covered by the segments of req and the number of frames
+// up to their end, or the whole video lasting duration without segments.
+// A video of unknown duration, which is 0, lasts Conversion.MaxDur frames.
+func segmentFrames(req *videopb.AnnotateVideoRequest, duration time.Duration) (int, int) {
+	segments := req.GetVideoContext().GetSegments()
+	if len(segments) == 0 {
+		if duration > 0 {
+			return 0, frameOf(duration) + 1
+		}
+		return 0, Conversion.MaxDur
+	}
+	start, end := segments[0].StartTimeOffset.AsDuration(), segments[0].EndTimeOffset.AsDuration()
+	for _, seg := range segments[1:] {
+		if d := seg.StartTimeOffset.AsDuration(); d < start {
+			start = d
+		}
+		if d := seg.EndTimeOffset.AsDuration(); d > end {
+			end = d
+		}
+	}
+	return frameOf(start), frameOf(end) - frameOf(start) + 1
+}
+
+// ChunkConfig splits a long video into overlapping segments annotated on their own.
+type ChunkConfig struct {
+	Length  time.Duration // of each chunk
+	Overlap time.Duration // shared by consecutive chunks, over which tracks are joined
+	// Mean screen distance under which tracks of consecutive chunks are the same person
+	MaxDistance float64
+}
+
+// Segment of a video annotated on its own
+type Chunk struct {
+	Index      int
+	Start, End time.Duration
+}
+
+// SplitChunks covers a video lasting duration, which must be positive, with chunks of cfg.
+func SplitChunks(duration time.Duration, cfg ChunkConfig) ([]Chunk, error) {
+	step := cfg.Length - cfg.Overlap
+	if cfg.Length <= 0 || cfg.Overlap < frameDuration || step <= 0 {
+		return nil, fmt.Errorf("invalid chunk config %+v", cfg)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid video duration %v", duration)
+	}
+	var ret []Chunk
+	for start := time.Duration(0); ; start += step {
+		end := start + cfg.Length
+		if end > duration {
+			end = duration
+		}
+		ret = append(ret, Chunk{Index: len(ret), Start: start, End: end})
+		if end == duration {
+			return ret, nil
+		}
+	}
+}
+
+// Name of the annotation of c, written to <name>.json next to the video
+func (c Chunk) Name(objName string) string {
+	return fmt.Sprintf("%s.chunk%02d", objName, c.Index)
+}
+
+// Options make a request annotating c of <objName>.mp4 in the bucket.
+func (c Chunk) Options(bucketName, objName string) []RequestOption {
+	return []RequestOption{
+		WithInputUri(fmt.Sprintf("gs://%s/%s.mp4", bucketName, objName)),
+		WithSegment(c.Start, c.End),
+	}
+}
+
+// GetChunkedSeries returns the series of objName annotated in chunks, each by a job
+// submitted as its Name with its Options, joined into series of the whole video.
+func (c Client) GetChunkedSeries(ctx context.Context, outDir, bucketName, objName string, chunks []Chunk, cfg ChunkConfig, opts ...RequestOption) ([]Series, error) {
+	parts := make([][]Series, len(chunks))
+	for k, chunk := range chunks {
+		copts := append(append([]RequestOption{}, opts...), chunk.Options(bucketName, objName)...)
+		series, err := c.GetSeries(ctx, outDir, bucketName, chunk.Name(objName), copts...)
+		if err != nil {
+			return nil, err
+		}
+		parts[k] = series
+	}
+	ret := JoinChunks(chunks, parts, cfg)
+	if err := PlotScreen(outDir, objName, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// JoinChunks joins the series of consecutive chunks, which start at the first frame of their chunk,
+// into series of the whole video. Tracks seen in the overlap of two chunks are joined in ascending
+// order of their mean screen distance there, if it is below cfg.MaxDistance, and switch from
+// the earlier chunk to the later one in the middle of the frames they share.
+func JoinChunks(chunks []Chunk, parts [][]Series, cfg ChunkConfig) []Series {
+	const minCommon = 3 // frames seen by both to join
+	if len(chunks) == 0 {
+		return nil
+	}
+	size := frameOf(chunks[len(chunks)-1].End) + 1
+
+	var ret []Series
+	var open []int // indices in ret of series which reach the end of the previous chunk
+	for k, c := range chunks {
+		shift := frameOf(c.Start)
+		next := make([]Series, len(parts[k]))
+		for i, sr := range parts[k] {
+			next[i] = shiftSeries(sr, shift, size)
+		}
+
+		type pair struct {
+			i, j int // in open and next
+			dist float64
+			cut  int
+		}
+		var pairs []pair
+		for i, idx := range open {
+			prev := ret[idx]
+			for j, sr := range next {
+				start, end := maxInt(prev.Start, sr.Start), minInt(prev.End, sr.End)
+				if end-start+1 < minCommon {
+					continue
+				}
+				dist := 0.
+				for t := start; t <= end; t++ {
+					dist += math.Hypot(prev.Plots[t].P-sr.Plots[t].P, prev.Plots[t].Q-sr.Plots[t].Q)
+				}
+				dist /= float64(end - start + 1)
+				if dist < cfg.MaxDistance {
+					pairs = append(pairs, pair{i, j, dist, (start + end + 1) / 2})
+				}
+			}
+		}
+		sort.SliceStable(pairs, func(a, b int) bool { return pairs[a].dist < pairs[b].dist })
+
+		joined := make(map[int]int) // next to ret
+		usedOpen := make(map[int]bool)
+		for _, p := range pairs {
+			if usedOpen[p.i] {
+				continue
+			}
+			if _, ok := joined[p.j]; ok {
+				continue
+			}
+			usedOpen[p.i] = true
+			joined[p.j] = open[p.i]
+			ret[open[p.i]] = joinSeries(ret[open[p.i]], next[p.j], p.cut)
+		}
+		for j, sr := range next {
+			if _, ok := joined[j]; !ok {
+				joined[j] = len(ret)
+				ret = append(ret, sr)
+			}
+		}
+
+		open = open[:0]
+		if k+1 < len(chunks) {
+			for j, sr := range next {
+				if sr.End >= frameOf(chunks[k+1].Start) {
+					open = append(open, joined[j])
+				}
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Conf > ret[j].Conf })
+	return ret
+}
+
+// shiftSeries moves the frames of sr later by shift into size frames.
+func shiftSeries(sr Series, shift, size int) Series {
+	ret := sr
+	ret.Start, ret.End = sr.Start+shift, sr.End+shift
+	ret.Plots = make([]ScreenPlot, size)
+	for t := sr.Start; t <= sr.End && t+shift < size; t++ {
+		ret.Plots[t+shift] = sr.Plots[t]
+	}
+	if ret.End >= size {
+		ret.End = size - 1
+	}
+	return ret
+}
+
+// joinSeries continues a with b from the frame cut on.
+// Confidences are averaged by the frames each series contributes.
+func joinSeries(a, b Series, cut int) Series {
+	ret := a
+	ret.Plots = append([]ScreenPlot{}, a.Plots...)
+	copy(ret.Plots[cut:b.End+1], b.Plots[cut:b.End+1])
+	ret.End = maxInt(a.End, b.End)
+	na, nb := float32(cut-a.Start), float32(ret.End-cut+1)
+	ret.Conf = (a.Conf*na + b.Conf*nb) / (na + nb)
+
+	type key struct{ name, value string }
+	confs := make(map[key]float32)
+	for _, attr := range a.Attributes {
+		confs[key{attr.Name, attr.Value}] += attr.Conf * na / (na + nb)
+	}
+	for _, attr := range b.Attributes {
+		confs[key{attr.Name, attr.Value}] += attr.Conf * nb / (na + nb)
+	}
+	ret.Attributes = make([]Attribute, 0, len(confs))
+	for k, conf := range confs {
+		ret.Attributes = append(ret.Attributes, Attribute{Name: k.name, Value: k.value, Conf: conf})
+	}
+	sort.Slice(ret.Attributes, func(i, j int) bool {
+		if ret.Attributes[i].Name != ret.Attributes[j].Name {
+			return ret.Attributes[i].Name < ret.Attributes[j].Name
+		}
+		return ret.Attributes[i].Value < ret.Attributes[j].Value
+	})
+	return ret
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
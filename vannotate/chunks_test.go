@@ -0,0 +1,79 @@
+package vannotate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitChunks(t *testing.T) {
+	cfg := ChunkConfig{Length: 30 * time.Second, Overlap: 10 * time.Second}
+	chunks, err := SplitChunks(50*time.Second, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chunk{
+		{Index: 0, Start: 0, End: 30 * time.Second},
+		{Index: 1, Start: 20 * time.Second, End: 50 * time.Second},
+	}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Errorf("got %+v, want %+v", chunks, want)
+	}
+
+	for _, duration := range []time.Duration{0, -time.Second} {
+		if chunks, err := SplitChunks(duration, cfg); err == nil {
+			t.Errorf("%v: got %+v, want an error", duration, chunks)
+		}
+	}
+}
+
+// track returns a series of a person at (p(t), q) over [start, end] of size frames.
+func track(conf float32, start, end, size int, q float64, p func(t int) float64) Series {
+	sr := Series{Conf: conf, Start: start, End: end, Plots: make([]ScreenPlot, size)}
+	for t := start; t <= end; t++ {
+		sr.Plots[t] = ScreenPlot{P: p(t), Q: q}
+	}
+	return sr
+}
+
+func TestJoinChunks(t *testing.T) {
+	cfg := ChunkConfig{Length: 30 * time.Second, Overlap: 10 * time.Second, MaxDistance: 0.05}
+	chunks, err := SplitChunks(50*time.Second, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames 200-300 of the video are in both chunks, each of which has 301 frames
+	walk := func(t int) float64 { return -0.4 + 0.001*float64(t) }
+	still := func(int) float64 { return 0.3 }
+	parts := [][]Series{
+		{
+			// Crosses into the second chunk within the overlap
+			track(0.9, 100, 300, 301, 0, walk),
+			// Leaves before the overlap
+			track(0.8, 10, 150, 301, 0.2, still),
+		},
+		{
+			track(0.9, 0, 200, 301, 0, func(t int) float64 { return walk(t + 200) }),
+			// Where the other one left, but in frames 250-450 of the video
+			track(0.7, 50, 250, 301, 0.2, still),
+		},
+	}
+	got := JoinChunks(chunks, parts, cfg)
+	if len(got) != 3 {
+		t.Fatalf("got %d series, want 3", len(got))
+	}
+	for k, want := range []struct{ start, end int }{{100, 400}, {10, 150}, {250, 450}} {
+		if sr := got[k]; sr.Start != want.start || sr.End != want.end || len(sr.Plots) != 501 {
+			t.Errorf("series %d: got %d-%d of %d frames, want %d-%d of 501", k, sr.Start, sr.End, len(sr.Plots), want.start, want.end)
+		}
+	}
+	for _, f := range []int{100, 249, 250, 400} {
+		if p := got[0].Plots[f].P; math.Abs(p-walk(f)) > 1e-9 {
+			t.Errorf("joined series at %d: got %v, want %v", f, p, walk(f))
+		}
+	}
+	if p := got[2].Plots[300].P; p != 0.3 {
+		t.Errorf("series of the second chunk at 300: got %v, want 0.3", p)
+	}
+}
@@ -0,0 +1,25 @@
+package vannotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	// ErrNotFound is returned for an object, annotation or file which does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidAnnotation is returned for an annotation which cannot be turned into series.
+	ErrInvalidAnnotation = errors.New("invalid annotation")
+)
+
+// notFound wraps an error of a store or the file system meaning that name does not exist
+// into ErrNotFound, and returns other errors as they are.
+func notFound(name string, err error) error {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return err
+}
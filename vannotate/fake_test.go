@@ -0,0 +1,56 @@
+package vannotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+)
+
+// fakeService stands in for the Video Intelligence API in tests of JobManager.
+// Each operation finishes after Polls polls, with the error in Fail for its input URI if any.
+// Operations outlive the JobManager using it like those of the real service.
+type fakeService struct {
+	Polls int
+	Fail  map[string]error
+
+	mu   sync.Mutex
+	ops  map[string]*fakeOperation
+	next int
+}
+
+type fakeOperation struct {
+	inputUri string
+	polls    int
+}
+
+func (fs *fakeService) Start(ctx context.Context, req *videopb.AnnotateVideoRequest) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if req.InputUri == "" {
+		return "", errors.New("no input uri")
+	}
+	if fs.ops == nil {
+		fs.ops = make(map[string]*fakeOperation)
+	}
+	name := fmt.Sprintf("projects/fake/locations/fake/operations/%d", fs.next)
+	fs.next++
+	fs.ops[name] = &fakeOperation{inputUri: req.InputUri}
+	return name, nil
+}
+
+func (fs *fakeService) Poll(ctx context.Context, name string) (bool, int32, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	op, ok := fs.ops[name]
+	if !ok {
+		return true, 0, fmt.Errorf("operation %s not found", name)
+	}
+	op.polls++
+	if op.polls < fs.Polls {
+		return false, int32(100 * op.polls / fs.Polls), nil
+	}
+	return true, 100, fs.Fail[op.inputUri]
+}
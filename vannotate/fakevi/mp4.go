@@ -0,0 +1,61 @@
+package fakevi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/payashi/vannotate/mp4"
+)
+
+// MP4 returns a video without frames whose metadata reads as info,
+// so that a fake bucket can hold videos of a given length, size and creation time.
+func MP4(info mp4.Info) []byte {
+	const timescale = 90000
+	created := uint32(0)
+	if !info.Created.IsZero() {
+		created = uint32(info.Created.Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second)
+	}
+	duration := uint32(info.Duration.Seconds() * timescale)
+	delta := uint32(0)
+	if info.Frames > 0 {
+		delta = duration / uint32(info.Frames)
+	}
+
+	mvhd := fields(uint32(0), created, created, uint32(timescale), duration, uint32(0x00010000), uint16(0x0100), make([]byte, 10), matrix(), make([]byte, 24), uint32(2))
+	tkhd := fields(uint32(3), created, created, uint32(1), uint32(0), duration, make([]byte, 8), uint16(0), uint16(0), uint16(0), uint16(0), matrix(),
+		uint32(info.Width)<<16, uint32(info.Height)<<16)
+	mdhd := fields(uint32(0), created, created, uint32(timescale), duration, uint16(0x55c4), uint16(0))
+	hdlr := fields(uint32(0), uint32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00"))
+	avc1 := fields(make([]byte, 6), uint16(1), make([]byte, 16), uint16(info.Width), uint16(info.Height),
+		uint32(0x00480000), uint32(0x00480000), uint32(0), uint16(1), make([]byte, 32), uint16(0x18), int16(-1))
+	stsd := fields(uint32(0), uint32(1), boxOf("avc1", avc1))
+	stts := fields(uint32(0), uint32(1), uint32(info.Frames), delta)
+
+	stbl := boxOf("stbl", boxOf("stsd", stsd), boxOf("stts", stts))
+	mdia := boxOf("mdia", boxOf("mdhd", mdhd), boxOf("hdlr", hdlr), boxOf("minf", stbl))
+	moov := boxOf("moov", boxOf("mvhd", mvhd), boxOf("trak", boxOf("tkhd", tkhd), mdia))
+	ftyp := boxOf("ftyp", []byte("isom"), fields(uint32(0x200)), []byte("isomavc1"))
+	return append(append(ftyp, boxOf("mdat")...), moov...)
+}
+
+// matrix is the identity transformation of a track
+func matrix() []byte {
+	return fields(uint32(0x00010000), uint32(0), uint32(0), uint32(0), uint32(0x00010000), uint32(0), uint32(0), uint32(0), uint32(0x40000000))
+}
+
+func fields(vs ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range vs {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	return buf.Bytes()
+}
+
+func boxOf(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	return append(fields(uint32(size), []byte(typ)), bytes.Join(payloads, nil)...)
+}
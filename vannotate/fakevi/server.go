@@ -0,0 +1,166 @@
+// Package fakevi serves a fake of the Video Intelligence API for development
+// without Google credentials. Point the Endpoint of a vannotate.Client at it and share its Store.
+package fakevi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"cloud.google.com/go/longrunning/autogen/longrunningpb"
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+	"github.com/payashi/vannotate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Server annotates videos with canned responses by input URI or synthetic persons otherwise.
+// Operations finish after Polls calls of GetOperation and write their output to Store.
+type Server struct {
+	videopb.UnimplementedVideoIntelligenceServiceServer
+	longrunningpb.UnimplementedOperationsServer
+
+	Store  vannotate.BlobStore
+	Polls  int
+	Canned map[string]*videopb.AnnotateVideoResponse
+
+	mu   sync.Mutex
+	ops  map[string]*operation
+	next int
+}
+
+type operation struct {
+	req   *videopb.AnnotateVideoRequest
+	polls int
+	op    *longrunningpb.Operation
+}
+
+func NewServer(store vannotate.BlobStore) *Server {
+	return &Server{Store: store, Polls: 1, ops: make(map[string]*operation)}
+}
+
+// Register adds the video and the operations services to gs.
+func (s *Server) Register(gs *grpc.Server) {
+	videopb.RegisterVideoIntelligenceServiceServer(gs, s)
+	longrunningpb.RegisterOperationsServer(gs, s)
+}
+
+// Start serves in the background on addr, e.g. "localhost:0",
+// and returns the address it listens on and a function to stop it.
+func (s *Server) Start(addr string) (string, func(), error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, err
+	}
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go gs.Serve(lis)
+	return lis.Addr().String(), gs.Stop, nil
+}
+
+func (s *Server) AnnotateVideo(ctx context.Context, req *videopb.AnnotateVideoRequest) (*longrunningpb.Operation, error) {
+	if req.InputUri == "" && len(req.InputContent) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "input_uri or input_content is required")
+	}
+	if len(req.Features) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "features are required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := fmt.Sprintf("projects/fake/locations/fake/operations/%d", s.next)
+	s.next++
+	o := &operation{req: req, op: &longrunningpb.Operation{Name: name}}
+	if err := o.setProgress(0); err != nil {
+		return nil, err
+	}
+	s.ops[name] = o
+	return o.snapshot(), nil
+}
+
+func (s *Server) GetOperation(ctx context.Context, req *longrunningpb.GetOperationRequest) (*longrunningpb.Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.ops[req.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %s not found", req.Name)
+	}
+	if o.op.Done {
+		return o.snapshot(), nil
+	}
+	o.polls++
+	if o.polls < s.Polls {
+		if err := o.setProgress(int32(100 * o.polls / s.Polls)); err != nil {
+			return nil, err
+		}
+		return o.snapshot(), nil
+	}
+
+	if err := o.setProgress(100); err != nil {
+		return nil, err
+	}
+	o.op.Done = true
+	resp, err := s.annotate(ctx, o.req)
+	if err != nil {
+		o.op.Result = &longrunningpb.Operation_Error{Error: status.Convert(err).Proto()}
+		return o.snapshot(), nil
+	}
+	packed, err := anypb.New(resp)
+	if err != nil {
+		return nil, err
+	}
+	o.op.Result = &longrunningpb.Operation_Response{Response: packed}
+	return o.snapshot(), nil
+}
+
+// Copy of the operation, which later calls may change while it is sent
+func (o *operation) snapshot() *longrunningpb.Operation {
+	return proto.Clone(o.op).(*longrunningpb.Operation)
+}
+
+func (o *operation) setProgress(percent int32) error {
+	meta, err := anypb.New(&videopb.AnnotateVideoProgress{
+		AnnotationProgress: []*videopb.VideoAnnotationProgress{{
+			InputUri:        o.req.InputUri,
+			ProgressPercent: percent,
+		}},
+	})
+	o.op.Metadata = meta
+	return err
+}
+
+// annotate makes the response to req and writes it to the output URI if any,
+// in the JSON the real service writes and vannotate reads.
+func (s *Server) annotate(ctx context.Context, req *videopb.AnnotateVideoRequest) (*videopb.AnnotateVideoResponse, error) {
+	resp, ok := s.Canned[req.InputUri]
+	if !ok {
+		resp = Synthetic(req)
+	}
+	if req.OutputUri == "" {
+		return resp, nil
+	}
+	bucketName, objName, err := vannotate.ParseGsUri(req.OutputUri)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.Store == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no store to write the output")
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.Store.NewWriter(ctx, bucketName, objName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return resp, w.Close()
+}
@@ -0,0 +1,72 @@
+package fakevi
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/payashi/vannotate"
+	"github.com/payashi/vannotate/mp4"
+)
+
+// Annotating a video of a local bucket through the fake API and reading back its series
+func TestAnnotateAndGetSeries(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := vannotate.DirStore{Root: filepath.Join(dir, "blobs")}
+	w, err := store.NewWriter(ctx, "bucket", "2022-12-07-0300-1t.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(MP4(mp4.Info{Duration: time.Minute, Frames: 600, Width: 1280, Height: 720}))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(store)
+	s.Polls = 2
+	addr, stop, err := s.Start("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	client := vannotate.Client{Endpoint: addr, Store: store}
+
+	vs, err := client.NewVideoService(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vs.Close()
+	jm, err := vannotate.NewJobManager(vs, filepath.Join(dir, "jobs.json"), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm.MinDelay, jm.MaxDelay = time.Millisecond, time.Millisecond
+	if err := jm.Submit(ctx, "2022-12-07-0300-1t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := jm.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if job := jm.Status()[0]; job.Status != vannotate.JobDone {
+		t.Fatalf("job %s: %s", job.Status, job.Error)
+	}
+
+	srList, err := client.GetSeries(ctx, dir, "bucket", "2022-12-07-0300-1t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srList) == 0 {
+		t.Fatal("no series")
+	}
+	// Series take a plot every 100ms of the video, both ends included
+	for i, sr := range srList {
+		if len(sr.Plots) != 601 {
+			t.Errorf("series %d has %d plots, want 601", i, len(sr.Plots))
+		}
+		if sr.Aspect != 1280./720. {
+			t.Errorf("series %d has aspect %v, want 16:9", i, sr.Aspect)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package fakevi
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Synthetic detects a few persons walking across the screen, the same ones for the same input URI.
+// Only frames within the segments of req are annotated; the video lasts a minute.
+func Synthetic(req *videopb.AnnotateVideoRequest) *videopb.AnnotateVideoResponse {
+	const (
+		duration = 60 * time.Second
+		step     = 100 * time.Millisecond
+		npersons = 4
+	)
+	colors := []string{"black", "white", "gray", "blue", "red", "green"}
+
+	h := fnv.New64a()
+	h.Write([]byte(req.InputUri))
+	rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	segments := req.GetVideoContext().GetSegments()
+	if len(segments) == 0 {
+		segments = []*videopb.VideoSegment{{
+			StartTimeOffset: durationpb.New(0),
+			EndTimeOffset:   durationpb.New(duration),
+		}}
+	}
+	inSegments := func(t time.Duration) bool {
+		for _, seg := range segments {
+			if seg.StartTimeOffset.AsDuration() <= t && t < seg.EndTimeOffset.AsDuration() {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := &videopb.VideoAnnotationResults{
+		InputUri: req.InputUri,
+		Segment: &videopb.VideoSegment{
+			StartTimeOffset: durationpb.New(0),
+			EndTimeOffset:   durationpb.New(duration),
+		},
+	}
+	hasPerson := false
+	for _, f := range req.Features {
+		hasPerson = hasPerson || f == videopb.Feature_PERSON_DETECTION
+	}
+	if !hasPerson {
+		return &videopb.AnnotateVideoResponse{AnnotationResults: []*videopb.VideoAnnotationResults{result}}
+	}
+
+	for k := 0; k < npersons; k++ {
+		start := time.Duration(rnd.Int63n(int64(duration/2/step))) * step
+		end := start + time.Duration(100+rnd.Int63n(200))*step
+		if end >= duration {
+			end = duration - step
+		}
+		// Walk from x0 to x1 with the feet moving from y0 to y1, growing as they come closer
+		x0, x1 := 0.1+0.8*rnd.Float32(), 0.1+0.8*rnd.Float32()
+		y0, y1 := 0.6+0.3*rnd.Float32(), 0.6+0.3*rnd.Float32()
+		upper, lower := colors[rnd.Intn(len(colors))], colors[rnd.Intn(len(colors))]
+
+		track := &videopb.Track{
+			Confidence: 0.5 + 0.5*rnd.Float32(),
+			Attributes: []*videopb.DetectedAttribute{
+				{Name: "UpperClothingColor", Value: upper, Confidence: 0.6 + 0.4*rnd.Float32()},
+				{Name: "LowerClothingColor", Value: lower, Confidence: 0.6 + 0.4*rnd.Float32()},
+			},
+		}
+		first, last := time.Duration(-1), time.Duration(-1)
+		for t := start; t <= end; t += step {
+			if !inSegments(t) {
+				continue
+			}
+			if first == -1 {
+				first = t
+			}
+			last = t
+			r := float32(t-start) / float32(end-start+step)
+			x, bottom := x0+(x1-x0)*r, y0+(y1-y0)*r
+			height := 0.5 * bottom
+			track.TimestampedObjects = append(track.TimestampedObjects, &videopb.TimestampedObject{
+				NormalizedBoundingBox: &videopb.NormalizedBoundingBox{
+					Left:   x - height/6,
+					Top:    bottom - height,
+					Right:  x + height/6,
+					Bottom: bottom,
+				},
+				TimeOffset: durationpb.New(t),
+			})
+		}
+		if first == -1 {
+			continue
+		}
+		track.Segment = &videopb.VideoSegment{
+			StartTimeOffset: durationpb.New(first),
+			EndTimeOffset:   durationpb.New(last),
+		}
+		result.PersonDetectionAnnotations = append(result.PersonDetectionAnnotations, &videopb.PersonDetectionAnnotation{
+			Tracks:  []*videopb.Track{track},
+			Version: "fake",
+		})
+	}
+	return &videopb.AnnotateVideoResponse{AnnotationResults: []*videopb.VideoAnnotationResults{result}}
+}
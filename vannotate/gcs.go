@@ -5,101 +5,86 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"os"
 	"sort"
 
-	"cloud.google.com/go/storage"
 	video "cloud.google.com/go/videointelligence/apiv1"
 	"cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
 	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
 )
 
-func SaveToGCS(bucketName string, objName string) {
-	var inputUri string = fmt.Sprintf("gs://%s/%s.mp4", bucketName, objName)
-	var outputUri string = fmt.Sprintf("gs://%s/%s.json", bucketName, objName)
-	ctx := context.Background()
-
+// SaveToGCS annotates <objName>.mp4 in the bucket into <objName>.json next to it,
+// detecting persons unless other features are given by opts.
+func (c Client) SaveToGCS(ctx context.Context, bucketName string, objName string, opts ...RequestOption) error {
 	// Creates a client.
-	client, err := video.NewClient(ctx)
+	client, err := video.NewClient(ctx, c.Options()...)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return fmt.Errorf("creating client: %w", err)
 	}
 	defer client.Close()
 
-	op, err := client.AnnotateVideo(ctx, &videopb.AnnotateVideoRequest{
-		InputUri:  inputUri,
-		OutputUri: outputUri,
-		Features: []videopb.Feature{
-			videopb.Feature_PERSON_DETECTION,
-		},
-		VideoContext: &videopb.VideoContext{
-			PersonDetectionConfig: &videopb.PersonDetectionConfig{
-				IncludeAttributes:    true,
-				IncludeBoundingBoxes: true,
-				IncludePoseLandmarks: true,
-			},
-		},
-	})
+	op, err := client.AnnotateVideo(ctx, newJobRequest(bucketName, objName, opts...))
 	if err != nil {
-		log.Fatalf("Failed to start annotation job: %v", err)
+		return fmt.Errorf("starting annotation of %s: %w", objName, err)
 	}
 
 	resp, err := op.Wait(ctx)
 	if err != nil {
-		log.Fatalf("Failed to annotate: %v", err)
-	}
-	ndetects := len(resp.AnnotationResults[0].PersonDetectionAnnotations)
-	fmt.Printf("%d detections", ndetects)
-}
-
-func loadFromGCS(bucketName string, objName string) []Series {
-	const maxDur = 601
-	// Download a json file
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("annotating %s: %w", objName, err)
 	}
-	bkt := client.Bucket(bucketName)
-
-	// Get the bucket's attributes
-	attrs, err := bkt.Attrs(ctx)
-	if err != nil {
-		log.Fatal(err)
+	ndetects := 0
+	if len(resp.AnnotationResults) > 0 {
+		ndetects = len(resp.AnnotationResults[0].PersonDetectionAnnotations)
 	}
-	fmt.Printf("bucket %s, created at %s, is located in %s with storage class %s\n",
-		attrs.Name, attrs.Created, attrs.Location, attrs.StorageClass)
+	fmt.Printf("%d detections\n", ndetects)
+	return nil
+}
 
+// loadFromGCS converts the annotation <objName>.json in the bucket into series of maxDur frames
+// from the frame first of the video. An annotation which does not fit is reported as ErrInvalidAnnotation.
+func loadFromGCS(ctx context.Context, store BlobStore, bucketName string, objName string, first, maxDur int) ([]Series, error) {
+	name := objName + ".json"
 	// Unmarshal a json file
-	obj := bkt.Object(objName + ".json")
-	r, err := obj.NewReader(ctx)
+	r, err := store.NewReader(ctx, bucketName, name)
 	if err != nil {
-		log.Fatal(err)
+		return nil, notFound(name, err)
 	}
 	defer r.Close()
-	slurp, _ := io.ReadAll(r)
+	slurp, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 	var res videointelligencepb.AnnotateVideoResponse
-	json.Unmarshal(slurp, &res)
 	if err := json.Unmarshal(slurp, &res); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w: %v", name, ErrInvalidAnnotation, err)
+	}
+	if len(res.AnnotationResults) == 0 {
+		return nil, fmt.Errorf("%s: %w: no annotation results", name, ErrInvalidAnnotation)
 	}
 
 	// Translate AnnotateVideoResponse to Series object
 	annots := res.AnnotationResults[0].PersonDetectionAnnotations
 	ret := make([]Series, len(annots))
 	for i, annot := range annots {
+		if len(annot.Tracks) == 0 || annot.Tracks[0].Segment == nil {
+			return nil, fmt.Errorf("%s: %w: person %d has no track", name, ErrInvalidAnnotation, i)
+		}
 		track := annot.Tracks[0]
 		tj := &ret[i]
 		tj.Plots = make([]ScreenPlot, maxDur)
 		tj.Conf = track.Confidence
-		tj.Start = int(track.Segment.StartTimeOffset.AsDuration().Milliseconds() / 100)
-		tj.End = int(track.Segment.EndTimeOffset.AsDuration().Milliseconds() / 100)
+		tj.Start = frameOf(track.Segment.StartTimeOffset.AsDuration()) - first
+		tj.End = frameOf(track.Segment.EndTimeOffset.AsDuration()) - first
+		tj.Attributes = summarizeAttributes(track)
+		if tj.Start < 0 || tj.End >= maxDur || tj.Start > tj.End {
+			return nil, fmt.Errorf("%s: %w: person %d in frames %d-%d beyond %d", name, ErrInvalidAnnotation, i, tj.Start, tj.End, maxDur)
+		}
 
 		for _, tsobj := range track.TimestampedObjects {
 			box := tsobj.NormalizedBoundingBox
-			tidx := tsobj.TimeOffset.AsDuration().Milliseconds() / 100
+			tidx := frameOf(tsobj.TimeOffset.AsDuration()) - first
+			if box == nil || tidx < 0 || tidx >= maxDur {
+				return nil, fmt.Errorf("%s: %w: person %d has a bad box at frame %d", name, ErrInvalidAnnotation, i, tidx)
+			}
 			tj.Plots[tidx] = ScreenPlot{
 				float64((box.Left+box.Right)/2) - 0.5,
 				0.5 - float64(box.Top),
@@ -107,34 +92,36 @@ func loadFromGCS(bucketName string, objName string) []Series {
 		}
 	}
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Conf > ret[j].Conf })
-	return ret
+	return ret, nil
 }
 
-func GetSeries(outDir, bucketName, objName string) []Series {
-	filePath := fmt.Sprintf("%s/%s.json", outDir, objName)
-	// If file is found, read and unmarshal it
-	if file, err := os.Open(filePath); err == nil {
-		defer file.Close()
-		b, err := ioutil.ReadAll(file)
-		if err != nil {
-			panic(err)
-		}
-		var ret []Series
-		if err := json.Unmarshal(b, &ret); err != nil {
-			panic(err)
+// summarizeAttributes scores every value of each attribute over the track and all of its frames.
+// Conf of a value is the sum of its confidences divided by the number of observations
+// of the attribute, so the values of one attribute sum up to at most 1.
+func summarizeAttributes(track *videopb.Track) []Attribute {
+	type key struct{ name, value string }
+	sums := make(map[key]float32)
+	counts := make(map[string]int)
+	add := func(attrs []*videopb.DetectedAttribute) {
+		for _, attr := range attrs {
+			sums[key{attr.Name, attr.Value}] += attr.Confidence
+			counts[attr.Name]++
 		}
-		return ret
-	} else {
-		fmt.Printf("Fetching %s...\n", objName)
-		series := loadFromGCS(bucketName, objName)
-		PlotScreen(outDir, objName, series)
+	}
+	add(track.Attributes)
+	for _, tsobj := range track.TimestampedObjects {
+		add(tsobj.Attributes)
+	}
 
-		// Save on local
-		newFile, err := json.MarshalIndent(series, "", "\t")
-		if err != nil {
-			panic(err)
-		}
-		_ = ioutil.WriteFile(filePath, newFile, 0644)
-		return series
+	ret := make([]Attribute, 0, len(sums))
+	for k, sum := range sums {
+		ret = append(ret, Attribute{Name: k.name, Value: k.value, Conf: sum / float32(counts[k.name])})
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return ret
 }
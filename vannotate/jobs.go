@@ -0,0 +1,260 @@
+package vannotate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	video "cloud.google.com/go/videointelligence/apiv1"
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+	"google.golang.org/api/option"
+)
+
+// AnnotationService is the part of the Video Intelligence API which JobManager uses
+type AnnotationService interface {
+	// Start submits req and returns the name of its long-running operation.
+	Start(ctx context.Context, req *videopb.AnnotateVideoRequest) (string, error)
+	// Poll checks the operation called name. An error with done unset is a failure
+	// to reach the service, and an error with done set is a failure of the operation.
+	Poll(ctx context.Context, name string) (done bool, progress int32, err error)
+}
+
+// Video Intelligence API
+type VideoService struct {
+	client *video.Client
+}
+
+// NewVideoService connects to c.Endpoint, or to Google Cloud if it is empty.
+func (c Client) NewVideoService(ctx context.Context, opts ...option.ClientOption) (*VideoService, error) {
+	client, err := video.NewClient(ctx, append(c.Options(), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &VideoService{client}, nil
+}
+
+func (vs *VideoService) Close() error {
+	return vs.client.Close()
+}
+
+func (vs *VideoService) Start(ctx context.Context, req *videopb.AnnotateVideoRequest) (string, error) {
+	op, err := vs.client.AnnotateVideo(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return op.Name(), nil
+}
+
+func (vs *VideoService) Poll(ctx context.Context, name string) (bool, int32, error) {
+	op := vs.client.AnnotateVideoOperation(name)
+	_, err := op.Poll(ctx)
+	progress := int32(0)
+	if meta, _ := op.Metadata(); meta != nil && len(meta.AnnotationProgress) > 0 {
+		progress = meta.AnnotationProgress[0].ProgressPercent
+	}
+	return op.Done(), progress, err
+}
+
+type JobStatus string
+
+const (
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Annotation job of one video
+type Job struct {
+	Object    string    `json:"object"`
+	Operation string    `json:"operation"`
+	Status    JobStatus `json:"status"`
+	Progress  int32     `json:"progress"` // percent
+	Error     string    `json:"error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// JobManager submits annotation jobs of objects in a bucket and keeps track of their
+// operations in a state file, so that a restarted process resumes waiting for them.
+type JobManager struct {
+	MinDelay, MaxDelay time.Duration // between polls
+
+	service    AnnotationService
+	statePath  string
+	bucketName string
+	opts       []RequestOption
+	mu         sync.Mutex
+	jobs       map[string]*Job
+}
+
+// NewJobManager loads the jobs saved in statePath, if any.
+// Jobs are annotated by newJobRequest with opts.
+func NewJobManager(service AnnotationService, statePath, bucketName string, opts ...RequestOption) (*JobManager, error) {
+	jm := &JobManager{
+		MinDelay:   5 * time.Second,
+		MaxDelay:   time.Minute,
+		service:    service,
+		statePath:  statePath,
+		bucketName: bucketName,
+		opts:       opts,
+		jobs:       make(map[string]*Job),
+	}
+	b, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return jm, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+	for _, job := range jobs {
+		jm.jobs[job.Object] = job
+	}
+	return jm, nil
+}
+
+// Submit starts annotating objName unless it is running or done already.
+func (jm *JobManager) Submit(ctx context.Context, objName string) error {
+	return jm.SubmitAs(ctx, objName)
+}
+
+// SubmitAs starts a job called objName, which writes <objName>.json, with opts on top of
+// those of jm, e.g. to annotate a segment of another video. It is skipped if running or done already.
+func (jm *JobManager) SubmitAs(ctx context.Context, objName string, opts ...RequestOption) error {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	if job, ok := jm.jobs[objName]; ok && job.Status != JobFailed {
+		return nil
+	}
+	req := newJobRequest(jm.bucketName, objName, append(append([]RequestOption{}, jm.opts...), opts...)...)
+	name, err := jm.service.Start(ctx, req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", objName, err)
+	}
+	jm.jobs[objName] = &Job{Object: objName, Operation: name, Status: JobRunning, Updated: time.Now()}
+	fmt.Printf("Submitted %s as %s\n", objName, name)
+	return jm.save()
+}
+
+// Wait polls the running jobs, backing off while none of them progresses,
+// until all of them finish or ctx is done.
+func (jm *JobManager) Wait(ctx context.Context) error {
+	delay := jm.MinDelay
+	for {
+		changed, running, err := jm.poll(ctx)
+		if err != nil {
+			return err
+		}
+		if running == 0 {
+			return nil
+		}
+		if changed {
+			delay = jm.MinDelay
+		} else if delay *= 2; delay > jm.MaxDelay {
+			delay = jm.MaxDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// poll checks every running job once and saves the state if any of them changed.
+// Failures to reach the service are retried by the next poll.
+func (jm *JobManager) poll(ctx context.Context) (bool, int, error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	changed, running := false, 0
+	for _, job := range jm.sorted() {
+		if job.Status != JobRunning {
+			continue
+		}
+		done, progress, err := jm.service.Poll(ctx, job.Operation)
+		if ctx.Err() != nil {
+			return changed, running, ctx.Err()
+		}
+		switch {
+		case done && err != nil:
+			job.Status, job.Error = JobFailed, err.Error()
+		case done:
+			job.Status, job.Progress = JobDone, 100
+		case err != nil:
+			fmt.Printf("Polling %s: %v\n", job.Object, err)
+			running++
+			continue
+		case progress != job.Progress:
+			job.Progress = progress
+		default:
+			running++
+			continue
+		}
+		job.Updated = time.Now()
+		changed = true
+		if job.Status == JobRunning {
+			running++
+		}
+		if job.Status == JobFailed {
+			fmt.Printf("%s: %s: %s\n", job.Object, job.Status, job.Error)
+		} else {
+			fmt.Printf("%s: %s (%d%%)\n", job.Object, job.Status, job.Progress)
+		}
+	}
+	if changed {
+		return changed, running, jm.save()
+	}
+	return changed, running, nil
+}
+
+// Status returns a copy of every job in the order of their objects.
+func (jm *JobManager) Status() []Job {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	ret := make([]Job, 0, len(jm.jobs))
+	for _, job := range jm.sorted() {
+		ret = append(ret, *job)
+	}
+	return ret
+}
+
+func (jm *JobManager) sorted() []*Job {
+	ret := make([]*Job, 0, len(jm.jobs))
+	for _, job := range jm.jobs {
+		ret = append(ret, job)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Object < ret[j].Object })
+	return ret
+}
+
+func (jm *JobManager) save() error {
+	return writeState(jm.statePath, jm.sorted())
+}
+
+// writeState replaces the state file at once so that a crash does not leave it half written.
+func writeState(statePath string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(statePath), filepath.Base(statePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), statePath)
+}
@@ -0,0 +1,112 @@
+package vannotate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestJobManager(t *testing.T, service AnnotationService, statePath string) *JobManager {
+	t.Helper()
+	jm, err := NewJobManager(service, statePath, "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jm.MinDelay, jm.MaxDelay = time.Millisecond, time.Millisecond
+	return jm
+}
+
+func TestJobManagerResumes(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jobs.json")
+	service := &fakeService{Polls: 3}
+	jm := newTestJobManager(t, service, statePath)
+	for _, objName := range []string{"a", "b"} {
+		if err := jm.Submit(context.Background(), objName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := jm.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted process waits for the operations started before
+	jm = newTestJobManager(t, service, statePath)
+	if err := jm.Submit(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if service.next != 2 {
+		t.Fatalf("resubmitted a running job: %d operations", service.next)
+	}
+	if err := jm.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	jobs := newTestJobManager(t, service, statePath).Status()
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Status != JobDone || job.Progress != 100 {
+			t.Errorf("%s: %s (%d%%), want done", job.Object, job.Status, job.Progress)
+		}
+	}
+}
+
+func TestJobManagerFails(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jobs.json")
+	service := &fakeService{Polls: 2, Fail: map[string]error{"gs://bucket/b.mp4": errors.New("broken video")}}
+	jm := newTestJobManager(t, service, statePath)
+	for _, objName := range []string{"a", "b"} {
+		if err := jm.Submit(context.Background(), objName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jm.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	jobs := jm.Status()
+	if jobs[0].Status != JobDone {
+		t.Errorf("a: %s, want done", jobs[0].Status)
+	}
+	if jobs[1].Status != JobFailed || jobs[1].Error != "broken video" {
+		t.Errorf("b: %s %q, want failed", jobs[1].Status, jobs[1].Error)
+	}
+
+	// Failed jobs are submitted again, done ones are not
+	delete(service.Fail, "gs://bucket/b.mp4")
+	for _, objName := range []string{"a", "b"} {
+		if err := jm.Submit(context.Background(), objName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if service.next != 3 {
+		t.Fatalf("got %d operations, want 3", service.next)
+	}
+	if err := jm.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if job := jm.Status()[1]; job.Status != JobDone {
+		t.Errorf("b: %s, want done", job.Status)
+	}
+}
+
+func TestJobManagerCancels(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jobs.json")
+	service := &fakeService{Polls: 1000}
+	jm := newTestJobManager(t, service, statePath)
+	if err := jm.Submit(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := jm.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The job is still running for the next process
+	jobs := newTestJobManager(t, service, statePath).Status()
+	if len(jobs) != 1 || jobs[0].Status != JobRunning {
+		t.Fatalf("got %+v, want a running job", jobs)
+	}
+}
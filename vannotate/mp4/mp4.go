@@ -0,0 +1,352 @@
+// Package mp4 reads the duration, frame rate, resolution and creation time of an MP4 or MOV file
+// from the boxes of its movie header, without decoding any frame.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNotMP4 is returned for a file without a movie box.
+var ErrNotMP4 = errors.New("mp4: no movie box")
+
+// Metadata of a video
+type Info struct {
+	Duration      time.Duration // of the movie
+	Frames        int           // samples of the video track
+	FrameRate     float64       // mean frames per second of the video track
+	Width, Height int           // as displayed, after the rotation of the track
+	Created       time.Time     // zero if unknown
+}
+
+// Aspect returns the ratio of width to height as displayed, or 0 if unknown.
+func (info Info) Aspect() float64 {
+	if info.Width == 0 || info.Height == 0 {
+		return 0
+	}
+	return float64(info.Width) / float64(info.Height)
+}
+
+// Times in boxes count seconds from 1904
+var epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ReadFile reads the metadata of the video at path.
+func ReadFile(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read finds the movie box among the top-level boxes of r and reads the metadata from it.
+// Other boxes, such as the media data, are skipped without being read.
+func Read(r io.ReaderAt) (Info, error) {
+	for off := int64(0); ; {
+		var hdr [16]byte
+		n, err := r.ReadAt(hdr[:], off)
+		if n < 8 {
+			if err == io.EOF || err == nil {
+				return Info{}, ErrNotMP4
+			}
+			return Info{}, err
+		}
+		size, typ, hlen := int64(binary.BigEndian.Uint32(hdr[0:4])), string(hdr[4:8]), int64(8)
+		switch size {
+		case 0: // to the end of the file
+			if typ != "moov" {
+				return Info{}, ErrNotMP4
+			}
+			b, err := readRest(r, off+hlen, 1<<30)
+			if err != nil {
+				return Info{}, fmt.Errorf("mp4: reading movie box: %w", err)
+			}
+			return parseMoov(b)
+		case 1:
+			if n < 16 {
+				return Info{}, fmt.Errorf("mp4: truncated box %q at %d", typ, off)
+			}
+			size, hlen = int64(binary.BigEndian.Uint64(hdr[8:16])), 16
+		}
+		if size < hlen {
+			return Info{}, fmt.Errorf("mp4: invalid size %d of box %q at %d", size, typ, off)
+		}
+		if typ != "moov" {
+			off += size
+			continue
+		}
+		if size > 1<<30 {
+			return Info{}, fmt.Errorf("mp4: movie box of %d bytes is too large", size)
+		}
+		b := make([]byte, size-hlen)
+		if _, err := r.ReadAt(b, off+hlen); err != nil {
+			return Info{}, fmt.Errorf("mp4: reading movie box: %w", err)
+		}
+		return parseMoov(b)
+	}
+}
+
+// readRest reads r from off to its end, failing beyond max bytes.
+func readRest(r io.ReaderAt, off, max int64) ([]byte, error) {
+	var ret []byte
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := r.ReadAt(buf, off+int64(len(ret)))
+		ret = append(ret, buf[:n]...)
+		if int64(len(ret)) > max {
+			return nil, fmt.Errorf("more than %d bytes", max)
+		}
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// box is a box within a buffer
+type box struct {
+	typ  string
+	data []byte // payload
+}
+
+// boxes splits b into the boxes it contains.
+func boxes(b []byte) ([]box, error) {
+	var ret []box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("mp4: truncated box header")
+		}
+		size, typ, hlen := uint64(binary.BigEndian.Uint32(b[0:4])), string(b[4:8]), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, errors.New("mp4: truncated box header")
+			}
+			size, hlen = binary.BigEndian.Uint64(b[8:16]), 16
+		}
+		if size < hlen || size > uint64(len(b)) {
+			return nil, fmt.Errorf("mp4: invalid size %d of box %q", size, typ)
+		}
+		ret = append(ret, box{typ, b[hlen:size]})
+		b = b[size:]
+	}
+	return ret, nil
+}
+
+// child returns the payload of the first box of typ in b, following the path of typs.
+func child(b []byte, typs ...string) ([]byte, bool) {
+	for _, typ := range typs {
+		bs, err := boxes(b)
+		if err != nil {
+			return nil, false
+		}
+		found := false
+		for _, bx := range bs {
+			if bx.typ == typ {
+				b, found = bx.data, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// reader reads big-endian fields of a full box, remembering the first error.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) skip(n int) {
+	if r.err == nil && len(r.b) < n {
+		r.err = errors.New("mp4: truncated box")
+	}
+	if r.err != nil {
+		return
+	}
+	r.b = r.b[n:]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.b
+	if r.skip(2); r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.b
+	if r.skip(4); r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) u64() uint64 {
+	b := r.b
+	if r.skip(8); r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// uint reads 64 bits in version 1 of a full box and 32 bits otherwise.
+func (r *reader) uint(version uint8) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
+
+// version reads the version and skips the flags of a full box.
+func (r *reader) version() uint8 {
+	v := r.u32()
+	return uint8(v >> 24)
+}
+
+// Header of a movie or a media: mvhd or mdhd
+type header struct {
+	created   uint64
+	timescale uint32
+	duration  uint64
+}
+
+func parseHeader(b []byte) (header, error) {
+	r := &reader{b: b}
+	v := r.version()
+	h := header{created: r.uint(v)}
+	r.uint(v) // modified
+	h.timescale = r.u32()
+	h.duration = r.uint(v)
+	if r.err == nil && h.timescale == 0 {
+		r.err = errors.New("mp4: zero timescale")
+	}
+	return h, r.err
+}
+
+func (h header) time() time.Time {
+	if h.created == 0 {
+		return time.Time{}
+	}
+	return epoch.Add(time.Duration(h.created) * time.Second)
+}
+
+func parseMoov(moov []byte) (Info, error) {
+	mvhd, ok := child(moov, "mvhd")
+	if !ok {
+		return Info{}, errors.New("mp4: no movie header")
+	}
+	mh, err := parseHeader(mvhd)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Duration: time.Duration(float64(mh.duration) / float64(mh.timescale) * float64(time.Second)),
+		Created:  mh.time(),
+	}
+
+	bs, err := boxes(moov)
+	if err != nil {
+		return Info{}, err
+	}
+	for _, bx := range bs {
+		if bx.typ != "trak" {
+			continue
+		}
+		if hdlr, ok := child(bx.data, "mdia", "hdlr"); !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+		if err := parseVideoTrack(bx.data, &info); err != nil {
+			return Info{}, err
+		}
+		return info, nil
+	}
+	return info, nil
+}
+
+// parseVideoTrack fills info with the frames and the size of a video track.
+func parseVideoTrack(trak []byte, info *Info) error {
+	mdhd, ok := child(trak, "mdia", "mdhd")
+	if !ok {
+		return errors.New("mp4: no media header")
+	}
+	mh, err := parseHeader(mdhd)
+	if err != nil {
+		return err
+	}
+	if info.Created.IsZero() {
+		info.Created = mh.time()
+	}
+
+	// Frames are the samples counted by the time-to-sample table
+	if stts, ok := child(trak, "mdia", "minf", "stbl", "stts"); ok {
+		r := &reader{b: stts}
+		r.version()
+		n := r.u32()
+		total := uint64(0)
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			count, delta := r.u32(), r.u32()
+			info.Frames += int(count)
+			total += uint64(count) * uint64(delta)
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if mh.duration == 0 {
+			mh.duration = total
+		}
+		if mh.duration > 0 {
+			info.FrameRate = float64(info.Frames) / (float64(mh.duration) / float64(mh.timescale))
+		}
+	}
+
+	// Size as displayed from the track header, or as coded from the sample description
+	if tkhd, ok := child(trak, "tkhd"); ok {
+		r := &reader{b: tkhd}
+		v := r.version()
+		r.uint(v) // created
+		r.uint(v) // modified
+		r.skip(8) // track id, reserved
+		r.uint(v) // duration
+		r.skip(16)
+		var matrix [9]int32
+		for i := range matrix {
+			matrix[i] = int32(r.u32())
+		}
+		w, h := int(r.u32()>>16), int(r.u32()>>16)
+		if r.err != nil {
+			return r.err
+		}
+		// Quarter turns swap width and height
+		if matrix[0] == 0 && matrix[4] == 0 && matrix[1] != 0 && matrix[3] != 0 {
+			w, h = h, w
+		}
+		info.Width, info.Height = w, h
+	}
+	if info.Width == 0 || info.Height == 0 {
+		if stsd, ok := child(trak, "mdia", "minf", "stbl", "stsd"); ok && len(stsd) >= 8 {
+			if entries, err := boxes(stsd[8:]); err == nil && len(entries) > 0 {
+				r := &reader{b: entries[0].data}
+				r.skip(8 + 16) // sample entry, pre-defined and reserved
+				w, h := int(r.u16()), int(r.u16())
+				if r.err == nil {
+					info.Width, info.Height = w, h
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package mp4_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/payashi/vannotate/fakevi"
+	"github.com/payashi/vannotate/mp4"
+)
+
+var info = mp4.Info{
+	Duration: 90 * time.Second,
+	Frames:   2700,
+	Width:    1920,
+	Height:   1080,
+	Created:  time.Date(2022, 12, 7, 3, 0, 0, 0, time.UTC),
+}
+
+func check(t *testing.T, b []byte) {
+	t.Helper()
+	got, err := mp4.Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := info
+	want.FrameRate = 30
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRead(t *testing.T) {
+	check(t, fakevi.MP4(info))
+}
+
+// The last box may have a size of 0 to run to the end of the file
+func TestReadSizeZero(t *testing.T) {
+	b := fakevi.MP4(info)
+	off := 0
+	for string(b[off+4:off+8]) != "moov" {
+		off += int(binary.BigEndian.Uint32(b[off : off+4]))
+	}
+	binary.BigEndian.PutUint32(b[off:off+4], 0)
+	check(t, b)
+}
+
+func TestReadTruncated(t *testing.T) {
+	b := fakevi.MP4(info)
+	if _, err := mp4.Read(bytes.NewReader(b[:len(b)-10])); err == nil {
+		t.Error("read a truncated movie box")
+	}
+}
+
+func TestReadNotMP4(t *testing.T) {
+	b := fakevi.MP4(info)
+	ftyp := binary.BigEndian.Uint32(b[0:4])
+	for _, b := range [][]byte{nil, []byte("not a video"), b[:ftyp]} {
+		if _, err := mp4.Read(bytes.NewReader(b)); !errors.Is(err, mp4.ErrNotMP4) {
+			t.Errorf("got %v for %q, want ErrNotMP4", err, b)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package vannotate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	videopb "cloud.google.com/go/videointelligence/apiv1/videointelligencepb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RequestOption sets up part of an annotation request
+type RequestOption func(req *videopb.AnnotateVideoRequest)
+
+// NewAnnotateRequest builds a request to annotate the video at inputUri.
+// Without any feature given, it detects persons as SaveToGCS always did.
+func NewAnnotateRequest(inputUri string, opts ...RequestOption) *videopb.AnnotateVideoRequest {
+	req := &videopb.AnnotateVideoRequest{
+		InputUri:     inputUri,
+		VideoContext: &videopb.VideoContext{},
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+	if len(req.Features) == 0 {
+		WithPersonDetection(DefaultPersonDetectionConfig())(req)
+	}
+	return req
+}
+
+func DefaultPersonDetectionConfig() *videopb.PersonDetectionConfig {
+	return &videopb.PersonDetectionConfig{
+		IncludeAttributes:    true,
+		IncludeBoundingBoxes: true,
+		IncludePoseLandmarks: true,
+	}
+}
+
+func addFeature(req *videopb.AnnotateVideoRequest, feature videopb.Feature) {
+	for _, f := range req.Features {
+		if f == feature {
+			return
+		}
+	}
+	req.Features = append(req.Features, feature)
+}
+
+// WithFeatures adds features which need no config, such as LABEL_DETECTION
+func WithFeatures(features ...videopb.Feature) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		for _, f := range features {
+			addFeature(req, f)
+		}
+	}
+}
+
+func WithPersonDetection(config *videopb.PersonDetectionConfig) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		addFeature(req, videopb.Feature_PERSON_DETECTION)
+		req.VideoContext.PersonDetectionConfig = config
+	}
+}
+
+func WithFaceDetection(config *videopb.FaceDetectionConfig) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		addFeature(req, videopb.Feature_FACE_DETECTION)
+		req.VideoContext.FaceDetectionConfig = config
+	}
+}
+
+func WithObjectTracking(config *videopb.ObjectTrackingConfig) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		addFeature(req, videopb.Feature_OBJECT_TRACKING)
+		req.VideoContext.ObjectTrackingConfig = config
+	}
+}
+
+// WithSegment limits the annotation to [start, end) of the video; it can be given more than once.
+func WithSegment(start, end time.Duration) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		req.VideoContext.Segments = append(req.VideoContext.Segments, &videopb.VideoSegment{
+			StartTimeOffset: durationpb.New(start),
+			EndTimeOffset:   durationpb.New(end),
+		})
+	}
+}
+
+// WithLocationId sets the region to annotate in, e.g. "us-east1"
+func WithLocationId(locationId string) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		req.LocationId = locationId
+	}
+}
+
+func WithInputUri(inputUri string) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		req.InputUri = inputUri
+	}
+}
+
+func WithOutputUri(outputUri string) RequestOption {
+	return func(req *videopb.AnnotateVideoRequest) {
+		req.OutputUri = outputUri
+	}
+}
+
+// newJobRequest builds the request of SaveToGCS, which reads <obj>.mp4 and writes <obj>.json in the bucket
+func newJobRequest(bucketName, objName string, opts ...RequestOption) *videopb.AnnotateVideoRequest {
+	defaults := []RequestOption{
+		WithOutputUri(fmt.Sprintf("gs://%s/%s.json", bucketName, objName)),
+	}
+	return NewAnnotateRequest(fmt.Sprintf("gs://%s/%s.mp4", bucketName, objName), append(defaults, opts...)...)
+}
+
+// WriteRequest writes req as the JSON body of the REST API, e.g. junks/request.json.
+func WriteRequest(w io.Writer, req *videopb.AnnotateVideoRequest) error {
+	b, err := protojson.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// protojson does not promise stable spacing
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "    "); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// DryRun writes the request SaveToGCS would send to w without sending it.
+func DryRun(w io.Writer, bucketName, objName string, opts ...RequestOption) error {
+	return WriteRequest(w, newJobRequest(bucketName, objName, opts...))
+}
@@ -0,0 +1,49 @@
+package vannotate
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// The default request is the one of junks/request.json sent with run.sh
+func TestWriteRequestMatchesJunks(t *testing.T) {
+	want, err := os.ReadFile("../junks/request.json")
+	if err != nil {
+		t.Skip(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteRequest(&buf, NewAnnotateRequest("gs://gcs-video-tracking/sample.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	// The file has no newline at its end
+	if got := buf.Bytes(); !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	b, err := os.ReadFile("../junks/request.json")
+	if err != nil {
+		t.Skip(err)
+	}
+	var want map[string]interface{}
+	if err := json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+	want["outputUri"] = "gs://gcs-video-tracking/sample.json"
+
+	var buf bytes.Buffer
+	if err := DryRun(&buf, "gcs-video-tracking", "sample"); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant the request of junks/request.json writing into the bucket", buf.Bytes())
+	}
+}
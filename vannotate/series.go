@@ -14,10 +14,22 @@ type ScreenPlot struct {
 	P, Q float64
 }
 
+// Attribute of a person such as clothing color, e.g. {"UpperClothingColor", "black", 0.8}
+type Attribute struct {
+	Name, Value string
+	Conf        float32
+}
+
+// Attributes of a person, several values of one attribute sharing its confidence
+type Attributes []Attribute
+
 type Series struct {
 	Conf       float32
 	Start, End int
-	Plots      []ScreenPlot
+	Plots      []ScreenPlot // one every 100ms of the video, whatever its frame rate
+	Attributes Attributes   `json:",omitempty"`
+	Fragments  []int        `json:",omitempty"` // indices of the original series if stitched
+	Aspect     float64      `json:",omitempty"` // of the video, 0 if unknown
 }
 
 func (sr Series) Len() float64 {
@@ -31,9 +43,20 @@ func (sr Series) Len() float64 {
 	return ret
 }
 
-func PlotScreen(outDir, fileName string, srList []Series) {
+// AspectOf returns the aspect ratio of the video of srList, or 16:9 if unknown.
+func AspectOf(srList []Series) float64 {
+	for _, sr := range srList {
+		if sr.Aspect > 0 {
+			return sr.Aspect
+		}
+	}
+	return 16. / 9.
+}
+
+// PlotScreen draws the screen plots of srList into <outDir>/<fileName>.png.
+func PlotScreen(outDir, fileName string, srList []Series) error {
 	const minConf float32 = 0.2
-	const ratio float64 = 16. / 9. // aspect ratio
+	ratio := AspectOf(srList)
 	p := plot.New()
 
 	p.X.Min = -0.5
@@ -54,7 +77,7 @@ func PlotScreen(outDir, fileName string, srList []Series) {
 		}
 		ploti, err := plotter.NewScatter(plots)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		ploti.GlyphStyle.Color = plotutil.Color(i)
@@ -66,7 +89,5 @@ func PlotScreen(outDir, fileName string, srList []Series) {
 	pwidth := 6 * vg.Inch
 	pheight, _ := vg.ParseLength(fmt.Sprintf("%.2fin", 6/ratio))
 
-	if err := p.Save(pwidth, pheight, fmt.Sprintf("%s/%s.png", outDir, fileName)); err != nil {
-		panic(err)
-	}
+	return p.Save(pwidth, pheight, fmt.Sprintf("%s/%s.png", outDir, fileName))
 }
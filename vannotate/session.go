@@ -0,0 +1,140 @@
+package vannotate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Name of a video, e.g. 2022-12-07-0300-1t for camera 1 of the session 2022-12-07-0300
+var objNameRe = regexp.MustCompile(`^(.*/)?(\d{4}-\d{2}-\d{2}-\d{4})-([12])t$`)
+
+// ParseObjectName splits objName into its session and camera (1 or 2).
+func ParseObjectName(objName string) (session string, cam int, ok bool) {
+	m := objNameRe.FindStringSubmatch(objName)
+	if m == nil {
+		return "", 0, false
+	}
+	return m[1] + m[2], int(m[3][0] - '0'), true
+}
+
+// Recording of both cameras at once
+type Session struct {
+	Name      string    // e.g. 2022-12-07-0300
+	Objects   [2]string // by camera, empty if missing
+	Annotated [2]bool   // whether <object>.json exists
+}
+
+// Complete reports whether both cameras have recorded the session.
+func (s Session) Complete() bool {
+	return s.Objects[0] != "" && s.Objects[1] != ""
+}
+
+// Pending returns the objects which have not been annotated yet.
+func (s Session) Pending() []string {
+	var ret []string
+	for i, obj := range s.Objects {
+		if obj != "" && !s.Annotated[i] {
+			ret = append(ret, obj)
+		}
+	}
+	return ret
+}
+
+// SessionOffset returns how much later camera 2 started recording s than camera 1,
+// by the creation times of the videos in the bucket, which are in seconds.
+func (c Client) SessionOffset(ctx context.Context, bucketName string, s Session) (time.Duration, error) {
+	store, closeStore, err := c.store(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer closeStore()
+	var created [2]time.Time
+	for i, obj := range s.Objects {
+		info, err := readVideoInfo(ctx, store, bucketName, obj+".mp4")
+		if err != nil {
+			return 0, err
+		}
+		if info.Created.IsZero() {
+			return 0, fmt.Errorf("%s.mp4: no creation time", obj)
+		}
+		created[i] = info.Created
+	}
+	return created[1].Sub(created[0]), nil
+}
+
+// AlignSeries moves the series of the camera which started later by offset, as returned by SessionOffset,
+// so that the same frame of both cameras is the same moment, and pads all of them to the same length.
+func AlignSeries(srList1, srList2 []Series, offset time.Duration) ([]Series, []Series) {
+	shift1, shift2 := 0, 0
+	if offset > 0 {
+		shift2 = frameOf(offset)
+	} else {
+		shift1 = frameOf(-offset)
+	}
+	size := maxInt(plotsLen(srList1)+shift1, plotsLen(srList2)+shift2)
+	return shiftAll(srList1, shift1, size), shiftAll(srList2, shift2, size)
+}
+
+func plotsLen(srList []Series) int {
+	ret := 0
+	for _, sr := range srList {
+		ret = maxInt(ret, len(sr.Plots))
+	}
+	return ret
+}
+
+func shiftAll(srList []Series, shift, size int) []Series {
+	ret := make([]Series, len(srList))
+	for i, sr := range srList {
+		ret[i] = shiftSeries(sr, shift, size)
+	}
+	return ret
+}
+
+// ListSessions groups the videos under prefix in the bucket into sessions in the order of their names.
+// Objects not following the naming convention are ignored.
+func (c Client) ListSessions(ctx context.Context, bucketName, prefix string) ([]Session, error) {
+	store, closeStore, err := c.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+	names, err := store.List(ctx, bucketName, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", bucketName, err)
+	}
+	return groupSessions(names), nil
+}
+
+// groupSessions groups the videos in names, which are sorted, into sessions.
+func groupSessions(names []string) []Session {
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = true
+	}
+
+	var ret []Session
+	index := make(map[string]int)
+	for _, name := range names {
+		obj := strings.TrimSuffix(name, ".mp4")
+		if obj == name {
+			continue
+		}
+		session, cam, ok := ParseObjectName(obj)
+		if !ok {
+			continue
+		}
+		i, ok := index[session]
+		if !ok {
+			i = len(ret)
+			index[session] = i
+			ret = append(ret, Session{Name: session})
+		}
+		ret[i].Objects[cam-1] = obj
+		ret[i].Annotated[cam-1] = exists[obj+".json"]
+	}
+	return ret
+}
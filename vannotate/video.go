@@ -0,0 +1,50 @@
+package vannotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/payashi/vannotate/mp4"
+)
+
+// blobReaderAt reads an object in ranges, so that only the boxes needed are fetched.
+type blobReaderAt struct {
+	ctx                 context.Context
+	store               BlobStore
+	bucketName, objName string
+}
+
+func (br blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r, err := br.store.NewRangeReader(br.ctx, br.bucketName, br.objName, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ReadVideoInfo reads the duration, frame rate, resolution and creation time of <objName>.mp4 in the bucket.
+func (c Client) ReadVideoInfo(ctx context.Context, bucketName, objName string) (mp4.Info, error) {
+	store, closeStore, err := c.store(ctx)
+	if err != nil {
+		return mp4.Info{}, err
+	}
+	defer closeStore()
+	return readVideoInfo(ctx, store, bucketName, objName+".mp4")
+}
+
+func readVideoInfo(ctx context.Context, store BlobStore, bucketName, name string) (mp4.Info, error) {
+	info, err := mp4.Read(blobReaderAt{ctx, store, bucketName, name})
+	if err = notFound(name, err); errors.Is(err, ErrNotFound) {
+		return mp4.Info{}, err
+	} else if err != nil {
+		return mp4.Info{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,228 @@
+package vannotate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// Notifier tells which objects have arrived in a bucket
+type Notifier interface {
+	// Next blocks until objects arrive and returns their names, which may repeat earlier ones.
+	Next(ctx context.Context) ([]string, error)
+}
+
+// PollNotifier lists the objects under Prefix in the bucket of Client every Interval.
+// An object is passed on once its size and modification time are the same in two polls in a row,
+// so that a video still being copied into the bucket is not taken for complete.
+type PollNotifier struct {
+	Client             Client
+	BucketName, Prefix string
+	Interval           time.Duration
+
+	polled bool
+	attrs  map[string]ObjectAttrs // of the objects not passed on yet, as of the last poll
+	stable map[string]bool
+}
+
+func (pn *PollNotifier) Next(ctx context.Context) ([]string, error) {
+	if pn.polled {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pn.Interval):
+		}
+	}
+	pn.polled = true
+	store, closeStore, err := pn.Client.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+	names, err := store.List(ctx, pn.BucketName, pn.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if pn.stable == nil {
+		pn.stable = make(map[string]bool)
+	}
+	ret := make([]string, 0, len(names))
+	attrs := make(map[string]ObjectAttrs)
+	for _, name := range names {
+		if pn.stable[name] {
+			ret = append(ret, name)
+			continue
+		}
+		a, err := store.Attrs(ctx, pn.BucketName, name)
+		if err = notFound(name, err); errors.Is(err, ErrNotFound) {
+			// Removed since listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if prev, ok := pn.attrs[name]; ok && prev.Size == a.Size && prev.Updated.Equal(a.Updated) {
+			pn.stable[name] = true
+			ret = append(ret, name)
+		} else {
+			attrs[name] = a
+		}
+	}
+	pn.attrs = attrs
+	return ret, nil
+}
+
+// ChanNotifier passes on the names sent to it, e.g. by a subscriber of storage notifications.
+type ChanNotifier <-chan string
+
+func (cn ChanNotifier) Next(ctx context.Context) ([]string, error) {
+	var ret []string
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case name, ok := <-cn:
+		if !ok {
+			return nil, fmt.Errorf("notifier closed")
+		}
+		ret = append(ret, name)
+	}
+	// Take whatever else has arrived meanwhile
+	for {
+		select {
+		case name, ok := <-cn:
+			if !ok {
+				return ret, nil
+			}
+			ret = append(ret, name)
+		default:
+			return ret, nil
+		}
+	}
+}
+
+// Processing of a session by Watcher
+type SessionRecord struct {
+	Session string    `json:"session"`
+	Status  JobStatus `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Watcher processes every session as soon as both of its videos have arrived.
+// Sessions are recorded in a state file so that a restarted watcher skips those done,
+// and retries those failed once.
+type Watcher struct {
+	notifier  Notifier
+	process   func(ctx context.Context, s Session) error
+	statePath string
+	names     map[string]bool
+	records   map[string]*SessionRecord
+	tried     map[string]bool
+}
+
+// NewWatcher loads the sessions saved in statePath, if any.
+func NewWatcher(notifier Notifier, statePath string, process func(ctx context.Context, s Session) error) (*Watcher, error) {
+	w := &Watcher{
+		notifier:  notifier,
+		process:   process,
+		statePath: statePath,
+		names:     make(map[string]bool),
+		records:   make(map[string]*SessionRecord),
+		tried:     make(map[string]bool),
+	}
+	b, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return w, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var records []*SessionRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+	for _, r := range records {
+		w.records[r.Session] = r
+	}
+	return w, nil
+}
+
+// Run processes sessions as they arrive until ctx is done or the notifier fails.
+func (w *Watcher) Run(ctx context.Context) error {
+	for {
+		names, err := w.notifier.Next(ctx)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			w.names[name] = true
+		}
+		for _, s := range w.ready() {
+			if err := w.run(ctx, s); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ready returns the complete sessions to process in the order of their names.
+func (w *Watcher) ready() []Session {
+	names := make([]string, 0, len(w.names))
+	for name := range w.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ret []Session
+	for _, s := range groupSessions(names) {
+		if !s.Complete() || w.tried[s.Name] {
+			continue
+		}
+		if r, ok := w.records[s.Name]; ok && r.Status == JobDone {
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// run processes s and saves how it went. Only a failure to save stops the watcher.
+func (w *Watcher) run(ctx context.Context, s Session) error {
+	w.tried[s.Name] = true
+	fmt.Printf("Processing %s...\n", s.Name)
+	r := &SessionRecord{Session: s.Name, Status: JobDone}
+	if err := w.process(ctx, s); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.Status, r.Error = JobFailed, err.Error()
+		fmt.Printf("%s: %s: %s\n", s.Name, r.Status, r.Error)
+	}
+	r.Updated = time.Now()
+	w.records[s.Name] = r
+	return w.save()
+}
+
+// Records returns a copy of every session recorded in the order of their names.
+func (w *Watcher) Records() []SessionRecord {
+	ret := make([]SessionRecord, 0, len(w.records))
+	for _, r := range w.sorted() {
+		ret = append(ret, *r)
+	}
+	return ret
+}
+
+func (w *Watcher) sorted() []*SessionRecord {
+	ret := make([]*SessionRecord, 0, len(w.records))
+	for _, r := range w.records {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Session < ret[j].Session })
+	return ret
+}
+
+func (w *Watcher) save() error {
+	return writeState(w.statePath, w.sorted())
+}
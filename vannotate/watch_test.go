@@ -0,0 +1,57 @@
+package vannotate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPollNotifierWaitsForCopies(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	write := func(name, content string, flag int) {
+		t.Helper()
+		path := filepath.Join(root, "bucket", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(path, flag|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(content)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pn := &PollNotifier{Client: Client{Store: DirStore{Root: root}}, BucketName: "bucket"}
+	next := func(want ...string) {
+		t.Helper()
+		names, err := pn.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 {
+			want = []string{}
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Fatalf("got %v, want %v", names, want)
+		}
+	}
+
+	write("s-1t.mp4", "whole", os.O_TRUNC)
+	write("s-2t.mp4", "half", os.O_TRUNC)
+	next()
+	// The copy of camera 2 goes on between the polls
+	write("s-2t.mp4", " and the rest", os.O_APPEND)
+	next("s-1t.mp4")
+	next("s-1t.mp4", "s-2t.mp4")
+
+	// Once passed on, objects are not checked again
+	time.Sleep(10 * time.Millisecond)
+	write("s-1t.mp4", "rewritten", os.O_TRUNC)
+	next("s-1t.mp4", "s-2t.mp4")
+}
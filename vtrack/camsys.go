@@ -1,20 +1,14 @@
 package vtrack
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"image/color"
-	"io/ioutil"
 	"math"
-	"os"
 
 	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/floats"
 	"gonum.org/v1/gonum/mat"
-	"gonum.org/v1/plot"
-	"gonum.org/v1/plot/plotter"
-	"gonum.org/v1/plot/plotutil"
-	"gonum.org/v1/plot/vg"
-	"gonum.org/v1/plot/vg/draw"
 )
 
 type Config struct {
@@ -32,6 +26,8 @@ type CameraSystem struct {
 	params  *mat.VecDense // theta1, theta2, phi, phi1, phi2
 	config  Config
 	tconfig TuneConfig
+	ground  Ground // nil for the flat floor
+	workers int    // set by SetParallelism
 }
 
 func NewCameraSystem(config Config) *CameraSystem {
@@ -53,14 +49,32 @@ func (cs CameraSystem) getConfig(cami int) (float64, float64, mat.VecDense) {
 	}
 }
 
-func (cs *CameraSystem) Tune(tconfig TuneConfig) {
+// Tune fits the angles of the cameras to tconfig.Plots until Ntrials steps are done or ctx is.
+// It fails with ErrInvalidCalibration if the result is not usable, and leaves cs as it was on any error.
+// InvalidFrames tells how many of the frames were dropped.
+func (cs *CameraSystem) Tune(ctx context.Context, tconfig TuneConfig) (err error) {
+	if tconfig.Plots == nil || tconfig.Plots.size == 0 {
+		return fmt.Errorf("%w: no plots to tune with", ErrInvalidCalibration)
+	}
+	params, prev := mat.VecDenseCopyOf(cs.params), cs.tconfig
+	defer func() {
+		if err != nil {
+			cs.params, cs.tconfig = params, prev
+		}
+	}()
 	cs.tconfig = tconfig
+	// Reused by every iteration, one for each distance of the gradient
+	bufs := make([]*projBuffer, 2*3)
+	for k := range bufs {
+		bufs[k] = newProjBuffer(maxInt(len(tconfig.Plots.pl1), len(tconfig.Plots.pl2)))
+	}
 	for i := 0; i < tconfig.Ntrials; i++ {
-		inc := mat.NewVecDense(cs.params.Len(), nil)
-		// Update theta1, theta2, phi
-		for j := 0; j < 3; j++ {
-			inc.SetVec(j, -cs.getDiff(j))
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		// Update theta1, theta2, phi
+		inc := cs.getGrad(3, bufs)
+		inc.ScaleVec(-1, inc)
 		inc.ScaleVec(1/inc.Norm(2), inc)
 		cs.params.AddScaledVec(
 			cs.params,
@@ -68,122 +82,35 @@ func (cs *CameraSystem) Tune(tconfig TuneConfig) {
 			inc,
 		)
 	}
-}
-
-func (cs CameraSystem) plotFrame(p *plot.Plot) {
-	frame := []vannotate.ScreenPlot{
-		{P: -0.5, Q: -0.5}, // bottom left
-		{P: +0.5, Q: -0.5}, // bottom right
-		{P: +0.5, Q: 0.},   // mid right
-		{P: -0.5, Q: 0.},   // mid left
-	}
-
-	// Plot lower part of frame of each camera
-	fm1 := cs.project(cs.params, 0, frame)
-	fm2 := cs.project(cs.params, 1, frame)
-	for i := 0; i < 4; i++ {
-		ni := (i + 1) % 4
-		ploti1, err := plotter.NewLine(plotter.XYs{
-			{X: fm1.At(i, 0), Y: fm1.At(i, 1)},
-			{X: fm1.At(ni, 0), Y: fm1.At(ni, 1)},
-		})
-		if err != nil {
-			panic(err)
-		}
-		ploti2, err := plotter.NewLine(plotter.XYs{
-			{X: fm2.At(i, 0), Y: fm2.At(i, 1)},
-			{X: fm2.At(ni, 0), Y: fm2.At(ni, 1)},
-		})
-		if err != nil {
-			panic(err)
+	for _, v := range cs.params.RawVector().Data {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: params diverged", ErrInvalidCalibration)
 		}
-		if i == 2 {
-			ploti1.LineStyle = draw.LineStyle{
-				Color: color.RGBA{255, 255, 255, 0},
-				Width: 3.,
-			}
-			ploti2.LineStyle = draw.LineStyle{
-				Color: color.RGBA{255, 255, 255, 0},
-				Width: 3.,
-			}
-		} else {
-			ploti1.Color = color.RGBA{0, 255, 255, 128}
-			ploti2.Color = color.RGBA{255, 0, 255, 128}
-		}
-		p.Add(ploti1)
-		p.Add(ploti2)
 	}
-	scatter, err := plotter.NewScatter(plotter.XYs{
-		{X: cs.config.C1.At(0, 0), Y: cs.config.C1.At(1, 0)},
-		{X: cs.config.C2.At(0, 0), Y: cs.config.C2.At(1, 0)},
-	})
-	if err != nil {
-		panic(err)
+	n1, n2 := cs.InvalidFrames()
+	if n1 == tconfig.Plots.size || n2 == tconfig.Plots.size {
+		return fmt.Errorf("%w: no frame of a camera hits the ground", ErrInvalidCalibration)
 	}
-	p.Add(scatter)
+	return nil
 }
 
-func (cs CameraSystem) PlotJoined(filePath string, iplots []IPlots) {
-	p := plot.New()
-	cs.plotFrame(p)
-	for i, iplot := range iplots {
-		fmt.Printf("iplots[%d]: %d-%d\n", i, iplot.i, iplot.j)
-		for j := 0; j < iplot.Size-1; j++ {
-			ploti, err := plotter.NewLine(plotter.XYs{
-				{X: iplot.Plots.At(j, 0), Y: iplot.Plots.At(j, 1)},
-				{X: iplot.Plots.At(j+1, 0), Y: iplot.Plots.At(j+1, 1)},
-			})
-			if err != nil {
-				panic(err)
-			}
-			ploti.Color = plotutil.Color(i)
-			p.Add(ploti)
-		}
-	}
-	p.Add(plotter.NewGrid())
-	p.X.Max = 15
-	p.X.Min = 0
-	p.Y.Max = 10
-	p.Y.Min = -30
-
-	if err := p.Save(vg.Inch*30, vg.Inch*30, filePath); err != nil {
-		panic(err)
+// InvalidFrames counts calibration frames whose rays miss the ground under the current params.
+func (cs CameraSystem) InvalidFrames() (int, int) {
+	plots := cs.tconfig.Plots
+	if plots == nil {
+		return 0, 0
 	}
+	_, ok1 := cs.project(cs.params, 0, plots.pl1)
+	_, ok2 := cs.project(cs.params, 1, plots.pl2)
+	return countInvalid(ok1), countInvalid(ok2)
 }
 
-func (cs CameraSystem) Plot(filePath string, srLists ...[]vannotate.Series) {
-	p := plot.New()
-	cs.plotFrame(p)
-
-	for cami := 0; cami <= 1; cami++ {
-		for _, sr := range srLists[cami] {
-			plots := sr.Plots[sr.Start : sr.End+1]
-			nplots := len(plots)
-			m := cs.project(cs.params, cami, plots)
-
-			for j := 0; j < nplots-1; j++ {
-				ploti, err := plotter.NewLine(plotter.XYs{
-					{X: m.At(j, 0), Y: m.At(j, 1)},
-					{X: m.At(j+1, 0), Y: m.At(j+1, 1)},
-				})
-				if err != nil {
-					panic(err)
-				}
-				ploti.Color = plotutil.Color(cami)
-				p.Add(ploti)
-			}
-		}
-	}
-
-	p.Add(plotter.NewGrid())
-	p.X.Max = -5
-	p.X.Min = +20
-	p.Y.Max = +5
-	p.Y.Min = -20
+func (cs CameraSystem) PlotJoined(filePath string, iplots []IPlots) error {
+	return plotJoined(cs, filePath, iplots)
+}
 
-	if err := p.Save(vg.Inch*30, vg.Inch*30, filePath); err != nil {
-		panic(err)
-	}
+func (cs CameraSystem) Plot(filePath string, srLists ...[]vannotate.Series) error {
+	return plotSeries(cs, filePath, srLists...)
 }
 
 func (cs CameraSystem) PrintUnityParams() {
@@ -233,99 +160,154 @@ func (cs CameraSystem) PrintUnityParams() {
 	)
 }
 
-func (m *CameraSystem) getDiff(i int) float64 {
-	cv := m.getPointsDistance(m.params)
-	nparams := mat.NewVecDense(m.params.Len(), nil)
-	nparams.SetVec(i, m.tconfig.Dp)
-	nparams.AddVec(m.params, nparams)
-	nv := m.getPointsDistance(nparams)
-	return (nv - cv) / m.tconfig.Dp
+// getGrad differentiates getPointsDistance by the first n params.
+// Adjusting phi1 and phi2 before each difference is cheap but changes cs.params,
+// so it runs in order and only the distances are computed in parallel, each into bufs[k].
+func (cs *CameraSystem) getGrad(n int, bufs []*projBuffer) *mat.VecDense {
+	points := make([]*mat.VecDense, 2*n)
+	for i := 0; i < n; i++ {
+		cs.adjustPhis(cs.params, bufs[0])
+		points[2*i] = mat.VecDenseCopyOf(cs.params)
+		nparams := mat.NewVecDense(cs.params.Len(), nil)
+		nparams.SetVec(i, cs.tconfig.Dp)
+		nparams.AddVec(cs.params, nparams)
+		cs.adjustPhis(nparams, bufs[0])
+		points[2*i+1] = nparams
+	}
+	dists := make([]float64, 2*n)
+	parallelFor(cs.workers, 2*n, func(k int) {
+		dists[k] = cs.getPointsDistance(points[k], bufs[k])
+	})
+
+	ret := mat.NewVecDense(cs.params.Len(), nil)
+	for i := 0; i < n; i++ {
+		ret.SetVec(i, (dists[2*i+1]-dists[2*i])/cs.tconfig.Dp)
+	}
+	return ret
 }
 
-func (cs CameraSystem) getPointsDistance(params *mat.VecDense) float64 {
-	// adjust phi1, phi2
-	phi1, phi2 := cs.getPhis(params)
+// adjustPhis turns phi1 and phi2 so that the calibration plots head along phi.
+func (cs *CameraSystem) adjustPhis(params *mat.VecDense, buf *projBuffer) {
+	phi1, phi2 := cs.getPhis(params, buf)
 	params.SetVec(3, phi1)
 	params.SetVec(4, phi2)
+}
+
+// Loss of a calibration frame whose rays miss the ground, in meters
+const invalidPenalty = 10.
 
-	m1 := cs.project(params, 0, cs.tconfig.Plots.pl1)
-	m2 := cs.project(params, 1, cs.tconfig.Plots.pl2)
+// getPointsDistance returns the mean distance between the cameras' projections over the frames
+// where both rays hit the ground, plus invalidPenalty for every other frame,
+// so that tuning cannot lower the loss by pushing frames above the horizon.
+func (cs CameraSystem) getPointsDistance(params *mat.VecDense, buf *projBuffer) float64 {
+	p1 := cs.newProjector(params, 0, cs.tconfig.Z0)
+	p2 := cs.newProjector(params, 1, cs.tconfig.Z0)
+	p1.projectInto(buf.pos[0], buf.valid[0], cs.tconfig.Plots.pl1)
+	p2.projectInto(buf.pos[1], buf.valid[1], cs.tconfig.Plots.pl2)
 
-	sum := .0
+	sum, nvalid := .0, 0
 	for i := 0; i < cs.tconfig.Plots.size; i++ {
-		d := mat.NewVecDense(3, nil)
-		d.SubVec(m1.RowView(i), m2.RowView(i))
-		sum += d.Norm(2)
+		if !buf.valid[0][i] || !buf.valid[1][i] {
+			continue
+		}
+		var d [3]float64
+		for k := 0; k < 3; k++ {
+			d[k] = buf.pos[0][3*i+k] - buf.pos[1][3*i+k]
+		}
+		sum += floats.Norm(d[:], 2)
+		nvalid++
 	}
-	return sum
+	ret := invalidPenalty * float64(cs.tconfig.Plots.size-nvalid)
+	if nvalid > 0 {
+		ret += sum / float64(nvalid)
+	}
+	return ret
 }
 
-func (cs *CameraSystem) getPhis(params *mat.VecDense) (float64, float64) {
+func (cs *CameraSystem) getPhis(params *mat.VecDense, buf *projBuffer) (float64, float64) {
 	// Get 2D plots
 	plots := cs.tconfig.Plots
-	pl1 := []vannotate.ScreenPlot{plots.pl1[0], plots.pl1[plots.size-1]}
-	pl2 := []vannotate.ScreenPlot{plots.pl2[0], plots.pl2[plots.size-1]}
-	m1 := cs.project(cs.params, 0, pl1)
-	m2 := cs.project(cs.params, 1, pl2)
-
-	d1 := mat.NewVecDense(3, nil)
-	d2 := mat.NewVecDense(3, nil)
-	d1.SubVec(m1.RowView(1), m1.RowView(0))
-	d2.SubVec(m2.RowView(1), m2.RowView(0))
-	t1 := math.Atan2(d1.At(1, 0), d1.At(0, 0))
-	t2 := math.Atan2(d2.At(1, 0), d2.At(0, 0))
+	t1 := cs.getDirection(0, plots.pl1, buf)
+	t2 := cs.getDirection(1, plots.pl2, buf)
 	phi := params.At(2, 0)
 	phi1 := params.At(3, 0) + phi - t1
 	phi2 := params.At(4, 0) + phi - t2
 	return phi1, phi2
 }
 
-func (cs *CameraSystem) project(params *mat.VecDense, cami int, plots []vannotate.ScreenPlot, args ...float64) *mat.Dense {
-	if cami < 0 || 1 < cami {
-		panic("cami should be 0 or 1")
+// Direction of the displacement between the first and the last valid plots
+func (cs *CameraSystem) getDirection(cami int, plots []vannotate.ScreenPlot, buf *projBuffer) float64 {
+	n := len(plots)
+	p := cs.newProjector(cs.params, cami, cs.tconfig.Z0)
+	var ends [6]float64
+	var endsOk [2]bool
+	p.projectInto(ends[:], endsOk[:], []vannotate.ScreenPlot{plots[0], plots[n-1]})
+	pos, first, last := ends[:], 0, 1
+	if !endsOk[0] || !endsOk[1] {
+		pos = buf.pos[cami]
+		p.projectInto(pos, buf.valid[cami], plots)
+		first, last = -1, -1
+		for i := 0; i < n; i++ {
+			if buf.valid[cami][i] {
+				if first == -1 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first == -1 {
+			return 0
+		}
 	}
-	theta, phi := params.At(0+cami, 0), params.At(3+cami, 0)
 
+	return math.Atan2(pos[3*last+1]-pos[3*first+1], pos[3*last]-pos[3*first])
+}
+
+// project intersects the ray of each plot with the surface Z0 above the ground.
+// The second return value reports whether the ray hits the plane in front of the camera;
+// rows of invalid plots are filled with NaN.
+func (cs *CameraSystem) project(params *mat.VecDense, cami int, plots []vannotate.ScreenPlot, args ...float64) (*mat.Dense, []bool) {
 	var z0 float64
 	if len(args) == 0 {
 		z0 = cs.tconfig.Z0
 	} else {
 		z0 = args[0]
 	}
+	p := cs.newProjector(params, cami, z0)
+	data := make([]float64, 3*len(plots))
+	valid := make([]bool, len(plots))
+	p.projectInto(data, valid, plots)
+	return mat.NewDense(len(plots), 3, data), valid
+}
 
-	n := mat.NewVecDense(3, []float64{
+// getBasis returns the optical axis n and the screen axes a, b of a camera.
+func getBasis(theta, phi float64) (*mat.VecDense, *mat.VecDense, *mat.VecDense) {
+	n, a, b := basis(theta, phi)
+	return mat.NewVecDense(3, n[:]), mat.NewVecDense(3, a[:]), mat.NewVecDense(3, b[:])
+}
+
+func basis(theta, phi float64) ([3]float64, [3]float64, [3]float64) {
+	n := [3]float64{
 		math.Cos(phi) * math.Cos(theta),
 		math.Sin(phi) * math.Cos(theta),
 		math.Sin(theta),
-	})
-	a := mat.NewVecDense(3, []float64{
+	}
+	a := [3]float64{
 		math.Sin(phi),
 		-math.Cos(phi),
 		0,
-	})
-	b := mat.NewVecDense(3, []float64{
+	}
+	b := [3]float64{
 		-math.Cos(phi) * math.Sin(theta),
 		-math.Sin(phi) * math.Sin(theta),
 		math.Cos(theta),
-	})
-
-	ret := mat.NewDense(len(plots), 3, nil)
-	r, k, c := cs.getConfig(cami)
-	for i, plot := range plots {
-		d := mat.NewVecDense(3, nil)
-		d.AddScaledVec(d, plot.P, a)
-		d.AddScaledVec(d, plot.Q/r, b)
-		d.AddScaledVec(n, k, d)
-		t := (z0 - c.At(2, 0)) / d.At(2, 0)
-
-		d.AddScaledVec(&c, t, d)
-		ret.SetRow(i, d.RawVector().Data)
 	}
-	return ret
+	return n, a, b
 }
 
 func (cs CameraSystem) MarshalJSON() ([]byte, error) {
 	v := &struct {
+		Model   string     `json:"model"`
 		Theta1  float64    `json:"theta1"`
 		Theta2  float64    `json:"theta2"`
 		Phi     float64    `json:"phi"`
@@ -339,6 +321,7 @@ func (cs CameraSystem) MarshalJSON() ([]byte, error) {
 		C2      []float64  `json:"c2"`
 		TConfig TuneConfig `json:"tconfig"`
 	}{
+		Model:   "pinhole",
 		Theta1:  cs.params.At(0, 0),
 		Theta2:  cs.params.At(1, 0),
 		Phi:     cs.params.At(2, 0),
@@ -371,7 +354,12 @@ func (cs *CameraSystem) UnmarshalJSON(b []byte) error {
 		C2      []float64  `json:"c2"`
 		TConfig TuneConfig `json:"tconfig"`
 	}{}
-	err := json.Unmarshal(b, cs2)
+	if err := json.Unmarshal(b, cs2); err != nil {
+		return err
+	}
+	if len(cs2.C1) != 3 || len(cs2.C2) != 3 {
+		return fmt.Errorf("camsys: %w: c1 and c2 should have 3 elements", ErrInvalidCalibration)
+	}
 	cs.params = mat.NewVecDense(5, []float64{
 		cs2.Theta1, cs2.Theta2,
 		cs2.Phi, cs2.Phi1, cs2.Phi2,
@@ -385,23 +373,19 @@ func (cs *CameraSystem) UnmarshalJSON(b []byte) error {
 		C2: *mat.NewVecDense(3, cs2.C2),
 	}
 	cs.tconfig = cs2.TConfig
-	return err
+	return nil
 }
 
+// LoadCameraSystem loads a camera system saved as json.
+// A file which is not a camera system is reported as ErrInvalidCalibration.
 func LoadCameraSystem(filePath string) (*CameraSystem, error) {
-	file, err := os.Open(filePath)
-	cs := &CameraSystem{}
+	b, err := readFile(filePath)
 	if err != nil {
-		return cs, err
-	}
-
-	defer file.Close()
-	b, err := ioutil.ReadAll(file)
-	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	cs := &CameraSystem{}
 	if err := json.Unmarshal(b, cs); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w: %v", filePath, ErrInvalidCalibration, err)
 	}
 	return cs, nil
 }
@@ -0,0 +1,135 @@
+package vtrack
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/payashi/vannotate"
+)
+
+// Pair of a series from camera1 and one from camera2
+type Link struct {
+	Series1 int `json:"series1"`
+	Series2 int `json:"series2"`
+}
+
+type SeriesRef struct {
+	Camera int `json:"camera"` // 1 or 2
+	Series int `json:"series"`
+}
+
+// Corrections to the identification made by hand.
+// Series are the indices given by vannotate.GetSeries, so they also refer to
+// the stitched series made of them and stay valid across reruns.
+// IPlots and Alternative report these IDs as Fragments1 and Fragments2,
+// and errors about the constraints name the series by them.
+type Constraints struct {
+	MustLink   []Link      `json:"mustlink"`
+	CannotLink []Link      `json:"cannotlink"`
+	Ignore     []SeriesRef `json:"ignore"`
+}
+
+// Relations of a pair under Constraints
+const (
+	cannotLink = -1
+	freeLink   = 0
+	mustLink   = 1
+)
+
+func LoadConstraints(filePath string) (Constraints, error) {
+	ret := Constraints{}
+	b, err := readFile(filePath)
+	if err != nil {
+		return ret, err
+	}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return ret, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return ret, nil
+}
+
+// IDs a series answers to: its fragments if it is stitched, or its own index
+func seriesIDs(sr vannotate.Series, idx int) []int {
+	if len(sr.Fragments) > 0 {
+		return sr.Fragments
+	}
+	return []int{idx}
+}
+
+// fragmentsOf returns the IDs of the series idxs of srList in ascending order.
+func fragmentsOf(srList []vannotate.Series, idxs []int) []int {
+	ret := make([]int, 0, len(idxs))
+	for _, idx := range idxs {
+		ret = append(ret, seriesIDs(srList[idx], idx)...)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// relations returns the relation of every pair of srList1 and srList2.
+// A series is ignored if any of its IDs is, and cannot-link wins over must-link.
+func (c Constraints) relations(srList1, srList2 []vannotate.Series) [][]int {
+	ids := [2][][]int{make([][]int, len(srList1)), make([][]int, len(srList2))}
+	ignored := [2][]bool{make([]bool, len(srList1)), make([]bool, len(srList2))}
+	for cami, srList := range [2][]vannotate.Series{srList1, srList2} {
+		for i, sr := range srList {
+			ids[cami][i] = seriesIDs(sr, i)
+			for _, ref := range c.Ignore {
+				if ref.Camera == cami+1 && contains(ids[cami][i], ref.Series) {
+					ignored[cami][i] = true
+				}
+			}
+		}
+	}
+
+	ret := make([][]int, len(srList1))
+	for i := range srList1 {
+		ret[i] = make([]int, len(srList2))
+		for j := range srList2 {
+			if ignored[0][i] || ignored[1][j] {
+				ret[i][j] = cannotLink
+				continue
+			}
+			for _, l := range c.MustLink {
+				if contains(ids[0][i], l.Series1) && contains(ids[1][j], l.Series2) {
+					ret[i][j] = mustLink
+				}
+			}
+			for _, l := range c.CannotLink {
+				if contains(ids[0][i], l.Series1) && contains(ids[1][j], l.Series2) {
+					ret[i][j] = cannotLink
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// oneToOne checks that no series is required by rels to match two others,
+// which identify cannot satisfy.
+func oneToOne(rels [][]int, srList1, srList2 []vannotate.Series) error {
+	linked := [2]map[int]int{{}, {}}
+	for i := range rels {
+		for j, rel := range rels[i] {
+			if rel != mustLink {
+				continue
+			}
+			i2, ok1 := linked[1][j]
+			j2, ok0 := linked[0][i]
+			if ok0 || ok1 {
+				if !ok1 {
+					i2 = i
+				}
+				if !ok0 {
+					j2 = j
+				}
+				return fmt.Errorf("must-links %v-%v and %v-%v: %w",
+					fragmentsOf(srList1, []int{i2}), fragmentsOf(srList2, []int{j2}),
+					fragmentsOf(srList1, []int{i}), fragmentsOf(srList2, []int{j}), ErrConflictingConstraints)
+			}
+			linked[0][i], linked[1][j] = j, i
+		}
+	}
+	return nil
+}
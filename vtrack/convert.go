@@ -1,7 +1,8 @@
 package vtrack
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"math"
 	"sort"
 
@@ -9,9 +10,37 @@ import (
 	"gonum.org/v1/gonum/mat"
 )
 
-func (cs CameraSystem) Idenitfy(srList1, srList2 []vannotate.Series) []IPlots {
+func (cs CameraSystem) Idenitfy(ctx context.Context, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	return identify(ctx, cs, srList1, srList2, cons)
+}
+
+// Costs are in m
+var geometricMatch = matchConfig{
+	maxLoss:     30,
+	weights:     CostBreakdown{Position: 1, TimeOverlap: 2, Appearance: 2, Velocity: 1},
+	temperature: 2,
+	nullCost:    10,
+}
+
+func identify(ctx context.Context, m CameraModel, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	mc := geometricMatch
+	mc.workers = parallelismOf(m)
+	return match(ctx, srList1, srList2, mc, cons, func(sr1, sr2 vannotate.Series) (IPlots, error) {
+		return newIplots(m, sr1, sr2)
+	})
+}
+
+// match greedily pairs series in ascending order of their cost,
+// which weighs the loss given by build with the other components of CostBreakdown.
+// Each match is then scored against the candidates it beat for either of its series.
+// Pairs forbidden by cons are never matched, and pairs it requires are matched first
+// regardless of their loss; a required pair which cannot be built is an error.
+func match(ctx context.Context, srList1, srList2 []vannotate.Series, mc matchConfig, cons Constraints, build func(sr1, sr2 vannotate.Series) (IPlots, error)) ([]IPlots, error) {
 	n1, n2 := len(srList1), len(srList2)
-	const MaxLoss float64 = 30
+	rels := cons.relations(srList1, srList2)
+	if err := oneToOne(rels, srList1, srList2); err != nil {
+		return nil, err
+	}
 
 	tdps := make([][]IPlots, n1)
 	for i := 0; i < n1; i++ {
@@ -20,6 +49,7 @@ func (cs CameraSystem) Idenitfy(srList1, srList2 []vannotate.Series) []IPlots {
 			tdps[i][j] = IPlots{
 				i: -1, j: -1,
 				Loss:  math.Inf(1),
+				Cost:  math.Inf(1),
 				Size:  0,
 				Plots: &mat.Dense{},
 				Start: 0,
@@ -28,17 +58,31 @@ func (cs CameraSystem) Idenitfy(srList1, srList2 []vannotate.Series) []IPlots {
 
 		}
 	}
+	builds, err := buildPairs(ctx, mc.workers, srList1, srList2, rels, build)
+	if err != nil {
+		return nil, err
+	}
 	for i, sr1 := range srList1 {
 		for j, sr2 := range srList2 {
-			ip, err := cs.newIplots(sr1, sr2)
+			if rels[i][j] == cannotLink {
+				continue
+			}
+			ip, err := builds[i*n2+j].ip, builds[i*n2+j].err
 			ip.i = i
 			ip.j = j
+			ip.Series1, ip.Series2 = []int{i}, []int{j}
+			ip.Fragments1, ip.Fragments2 = fragmentsOf(srList1, ip.Series1), fragmentsOf(srList2, ip.Series2)
 			if err != nil {
+				if rels[i][j] == mustLink {
+					return nil, fmt.Errorf("must-link %v-%v: %w", ip.Fragments1, ip.Fragments2, err)
+				}
 				continue
 			}
-			if ip.Loss > MaxLoss {
+			if ip.Loss > mc.maxLoss && rels[i][j] != mustLink {
 				continue
 			}
+			ip.setCost([]vannotate.Series{sr1}, []vannotate.Series{sr2}, mc)
+			ip.Forced = rels[i][j] == mustLink
 			tdps[i][j] = ip
 		}
 	}
@@ -57,8 +101,12 @@ func (cs CameraSystem) Idenitfy(srList1, srList2 []vannotate.Series) []IPlots {
 					continue
 				}
 				tdp := &tdps[i][j]
-				if best > tdp.Loss {
-					best = tdp.Loss
+				cost := tdp.Cost
+				if tdp.Forced {
+					cost = math.Inf(-1)
+				}
+				if best > cost {
+					best = cost
 					argmini, argminj = tdp.i, tdp.j
 				}
 			}
@@ -68,52 +116,153 @@ func (cs CameraSystem) Idenitfy(srList1, srList2 []vannotate.Series) []IPlots {
 		}
 		usedis = append(usedis, argmini)
 		usedjs = append(usedjs, argminj)
-		ret = append(ret, tdps[argmini][argminj])
+		ip := tdps[argmini][argminj]
+		alts := make([]Alternative, 0)
+		for i := 0; i < n1; i++ {
+			for j := 0; j < n2; j++ {
+				if (i == argmini) == (j == argminj) || math.IsInf(tdps[i][j].Cost, 1) {
+					continue
+				}
+				alts = append(alts, Alternative{
+					Series1: []int{i}, Series2: []int{j},
+					Fragments1: tdps[i][j].Fragments1, Fragments2: tdps[i][j].Fragments2,
+					Cost: tdps[i][j].Cost,
+				})
+			}
+		}
+		ip.explain(alts, mc)
+		ret = append(ret, ip)
 	}
-	sort.Slice(ret, func(i, j int) bool { return ret[i].Loss < ret[j].Loss })
-	return ret
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Cost < ret[j].Cost })
+	return ret, nil
+}
+
+// Result of build for a pair of series
+type builtPair struct {
+	ip  IPlots
+	err error
+}
+
+// buildPairs integrates every pair not forbidden by rels on workers until ctx is done.
+// The result of srList1[i] and srList2[j] is at i*len(srList2)+j.
+func buildPairs(ctx context.Context, workers int, srList1, srList2 []vannotate.Series, rels [][]int, build func(sr1, sr2 vannotate.Series) (IPlots, error)) ([]builtPair, error) {
+	n2 := len(srList2)
+	ret := make([]builtPair, len(srList1)*n2)
+	parallelFor(workers, len(ret), func(k int) {
+		i, j := k/n2, k%n2
+		if rels[i][j] != cannotLink && ctx.Err() == nil {
+			ret[k].ip, ret[k].err = build(srList1[i], srList2[j])
+		}
+	})
+	return ret, ctx.Err()
 }
 
-func (cs CameraSystem) newIplots(sr1, sr2 vannotate.Series) (IPlots, error) {
-	m1 := cs.project(cs.params, 0, sr1.Plots)
-	m2 := cs.project(cs.params, 1, sr2.Plots)
-	// overwrapped range
-	start, end := maxInt(sr1.Start, sr2.Start), minInt(sr1.End, sr2.End)
-	if start > end {
-		return IPlots{}, errors.New("no overwrapped range")
+func newIplots(m CameraModel, sr1, sr2 vannotate.Series) (IPlots, error) {
+	ret, err := newGroupIplots(m, []vannotate.Series{sr1}, []vannotate.Series{sr2})
+	ret.sr1, ret.sr2 = sr1, sr2
+	return ret, err
+}
+
+// Observations of a group of series in one camera
+type camObs struct {
+	pos     *mat.Dense // NaN where unseen or invalid
+	conf    []float32
+	covered []bool // whether any series of the group spans the frame
+	invalid int
+}
+
+func newCamObs(m CameraModel, cami int, srs []vannotate.Series, start, size int) (camObs, error) {
+	ret := camObs{
+		pos:     mat.NewDense(size, 3, nil),
+		conf:    make([]float32, size),
+		covered: make([]bool, size),
+	}
+	for t := 0; t < size; t++ {
+		ret.pos.SetRow(t, []float64{math.NaN(), math.NaN(), math.NaN()})
 	}
+	for _, sr := range srs {
+		pm, valid, err := m.Project(cami, sr.Plots)
+		if err != nil {
+			return ret, err
+		}
+		ret.invalid += countInvalid(valid[sr.Start : sr.End+1])
+		for t := sr.Start; t <= sr.End; t++ {
+			ret.covered[t-start] = true
+			if valid[t] {
+				ret.pos.SetRow(t-start, pm.RawRowView(t))
+				ret.conf[t-start] = sr.Conf
+			}
+		}
+	}
+	return ret, nil
+}
 
+// newGroupIplots integrates a group of series from each camera.
+// Series of a group must not overlap each other in time.
+func newGroupIplots(m CameraModel, srs1, srs2 []vannotate.Series) (IPlots, error) {
 	ret := IPlots{}
-	ret.sr1, ret.sr2 = sr1, sr2
-	ret.Start = minInt(sr1.Start, sr2.Start)
-	ret.End = maxInt(sr1.End, sr2.End)
+	ret.Start, ret.End = math.MaxInt, math.MinInt
+	for _, sr := range append(append([]vannotate.Series{}, srs1...), srs2...) {
+		ret.Start = minInt(ret.Start, sr.Start)
+		ret.End = maxInt(ret.End, sr.End)
+	}
 	ret.Size = ret.End - ret.Start + 1
+	o1, err := newCamObs(m, 0, srs1, ret.Start, ret.Size)
+	if err != nil {
+		return ret, err
+	}
+	o2, err := newCamObs(m, 1, srs2, ret.Start, ret.Size)
+	if err != nil {
+		return ret, err
+	}
+	ret.Invalid1, ret.Invalid2 = o1.invalid, o2.invalid
 
-	// Calculate loss
+	// Calculate loss over frames valid in both cameras
 	ret.Loss = .0
-	for t := start; t <= end; t++ {
+	noverlap, nvalid := 0, 0
+	for t := 0; t < ret.Size; t++ {
+		if !o1.covered[t] || !o2.covered[t] {
+			continue
+		}
+		noverlap++
+		if math.IsNaN(o1.pos.At(t, 0)) || math.IsNaN(o2.pos.At(t, 0)) {
+			continue
+		}
 		diff := mat.NewVecDense(3, nil)
-		diff.SubVec(m1.RowView(t), m2.RowView(t))
+		diff.SubVec(o1.pos.RowView(t), o2.pos.RowView(t))
 		ret.Loss += diff.Norm(2)
+		nvalid++
 	}
-	ret.Loss /= float64(end - start + 1)
+	if noverlap == 0 {
+		return IPlots{}, ErrNoOverlap
+	}
+	if nvalid == 0 {
+		return IPlots{}, fmt.Errorf("%w: no frame valid in both cameras", ErrNoOverlap)
+	}
+	ret.Loss /= float64(nvalid)
+
+	ret.obs[0], ret.obs[1] = o1.pos, o2.pos
 
 	ret.Plots = mat.NewDense(ret.Size, 3, nil)
-	for t := ret.Start; t <= ret.End; t++ {
-		// p1, p2 := sr1.Plots[t], sr2.Plots[t]
-		in1 := sr1.Start <= t && t <= sr1.End
-		in2 := sr2.Start <= t && t <= sr2.End
-		p := mat.NewVecDense(3, nil)
+	ret.Disagreement = make([]float64, ret.Size)
+	for t := 0; t < ret.Size; t++ {
+		in1 := !math.IsNaN(o1.pos.At(t, 0))
+		in2 := !math.IsNaN(o2.pos.At(t, 0))
+		ret.Disagreement[t] = math.NaN()
 		if in1 && in2 {
-			p.AddVec(m1.RowView(t), m2.RowView(t))
-			p.ScaleVec(0.5, p)
-			ret.Plots.SetRow(t-ret.Start, p.RawVector().Data)
+			p, dist, err := fuse(m, o1.pos.RawRowView(t), o2.pos.RawRowView(t), o1.conf[t], o2.conf[t])
+			if err != nil {
+				return IPlots{}, err
+			}
+			ret.Plots.SetRow(t, p)
+			ret.Disagreement[t] = dist
 		} else if in1 {
-			ret.Plots.SetRow(t-ret.Start, m1.RawRowView(t))
+			ret.Plots.SetRow(t, o1.pos.RawRowView(t))
 		} else if in2 {
-			ret.Plots.SetRow(t-ret.Start, m2.RawRowView(t))
+			ret.Plots.SetRow(t, o2.pos.RawRowView(t))
 		} else {
-			panic("invalid timestamp")
+			// Neither camera sees the ground at t
+			ret.Plots.SetRow(t, []float64{math.NaN(), math.NaN(), math.NaN()})
 		}
 	}
 
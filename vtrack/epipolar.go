@@ -0,0 +1,194 @@
+package vtrack
+
+import (
+	"context"
+	"math"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Rotation from camera coordinates (a, b, n) to world coordinates
+func (cs CameraSystem) rotation(cami int) *mat.Dense {
+	theta, phi := cs.params.At(0+cami, 0), cs.params.At(3+cami, 0)
+	n, a, b := getBasis(theta, phi)
+	ret := mat.NewDense(3, 3, nil)
+	ret.SetCol(0, a.RawVector().Data)
+	ret.SetCol(1, b.RawVector().Data)
+	ret.SetCol(2, n.RawVector().Data)
+	return ret
+}
+
+// Homogeneous image coordinates of a screen plot
+func (cs CameraSystem) imageCoord(cami int, plot vannotate.ScreenPlot) *mat.VecDense {
+	r, _, _ := cs.getConfig(cami)
+	return mat.NewVecDense(3, []float64{plot.P, plot.Q / r, 1})
+}
+
+// Fundamental returns the fundamental matrix F such that x2ᵀ F x1 = 0
+// for image coordinates (P, Q/R, 1) of the same world point seen by both cameras.
+func (cs CameraSystem) Fundamental() *mat.Dense {
+	_, k1, c1 := cs.getConfig(0)
+	_, k2, c2 := cs.getConfig(1)
+	kinv1 := mat.NewDiagDense(3, []float64{k1, k1, 1})
+	kinv2 := mat.NewDiagDense(3, []float64{k2, k2, 1})
+
+	base := mat.NewVecDense(3, nil)
+	base.SubVec(&c2, &c1)
+	bx, by, bz := base.At(0, 0), base.At(1, 0), base.At(2, 0)
+	cross := mat.NewDense(3, 3, []float64{
+		0, -bz, by,
+		bz, 0, -bx,
+		-by, bx, 0,
+	})
+
+	ret := mat.NewDense(3, 3, nil)
+	ret.Product(kinv2.T(), cs.rotation(1).T(), cross, cs.rotation(0), kinv1)
+	return ret
+}
+
+// EpipolarDistance returns the symmetric distance of two screen plots
+// from each other's epipolar lines, in image coordinates.
+func (cs CameraSystem) EpipolarDistance(p1, p2 vannotate.ScreenPlot) float64 {
+	return epipolarDistance(cs.Fundamental(), cs.imageCoord(0, p1), cs.imageCoord(1, p2))
+}
+
+func epipolarDistance(f *mat.Dense, x1, x2 *mat.VecDense) float64 {
+	l2 := mat.NewVecDense(3, nil)
+	l2.MulVec(f, x1)
+	l1 := mat.NewVecDense(3, nil)
+	l1.MulVec(f.T(), x2)
+	e := mat.Dot(x2, l2)
+	d1 := math.Abs(e) / math.Hypot(l1.At(0, 0), l1.At(1, 0))
+	d2 := math.Abs(e) / math.Hypot(l2.At(0, 0), l2.At(1, 0))
+	return (d1 + d2) / 2
+}
+
+// EpipolarError returns the mean epipolar distance of two series over their overlap.
+// It is independent of Z0, so it can be used to check a calibration.
+func (cs CameraSystem) EpipolarError(sr1, sr2 vannotate.Series) (float64, error) {
+	sp, err := NewSyncedPlots(sr1, sr2)
+	if err != nil {
+		return 0, err
+	}
+	f := cs.Fundamental()
+	ret := .0
+	for i := 0; i < sp.size; i++ {
+		ret += epipolarDistance(f, cs.imageCoord(0, sp.pl1[i]), cs.imageCoord(1, sp.pl2[i]))
+	}
+	return ret / float64(sp.size), nil
+}
+
+// Ray of a screen plot in world coordinates
+func (cs CameraSystem) ray(cami int, plot vannotate.ScreenPlot) *mat.VecDense {
+	theta, phi := cs.params.At(0+cami, 0), cs.params.At(3+cami, 0)
+	n, a, b := getBasis(theta, phi)
+	r, k, _ := cs.getConfig(cami)
+	d := mat.NewVecDense(3, nil)
+	d.AddScaledVec(d, plot.P, a)
+	d.AddScaledVec(d, plot.Q/r, b)
+	d.AddScaledVec(n, k, d)
+	return d
+}
+
+// triangulate returns the midpoint of the closest points of two rays.
+func (cs CameraSystem) triangulate(p1, p2 vannotate.ScreenPlot) (*mat.VecDense, bool) {
+	_, _, c1 := cs.getConfig(0)
+	_, _, c2 := cs.getConfig(1)
+	d1, d2 := cs.ray(0, p1), cs.ray(1, p2)
+
+	w := mat.NewVecDense(3, nil)
+	w.SubVec(&c1, &c2)
+	a, b, c := mat.Dot(d1, d1), mat.Dot(d1, d2), mat.Dot(d2, d2)
+	d, e := mat.Dot(d1, w), mat.Dot(d2, w)
+	den := a*c - b*b
+	if den < 1e-12 {
+		// Parallel rays
+		return nil, false
+	}
+	s := (b*e - c*d) / den
+	t := (a*e - b*d) / den
+	if s <= 0 || t <= 0 {
+		// Rays cross behind a camera
+		return nil, false
+	}
+	q1 := mat.NewVecDense(3, nil)
+	q1.AddScaledVec(&c1, s, d1)
+	q2 := mat.NewVecDense(3, nil)
+	q2.AddScaledVec(&c2, t, d2)
+	q1.AddVec(q1, q2)
+	q1.ScaleVec(0.5, q1)
+	return q1, true
+}
+
+// IdenitfyEpipolar matches series by their epipolar distance instead of
+// the distance of their projections, so it does not depend on Z0.
+// Loss of the result is the mean epipolar distance in image coordinates,
+// and positions seen by both cameras are triangulated.
+func (cs CameraSystem) IdenitfyEpipolar(ctx context.Context, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	// Costs are in image units
+	mc := matchConfig{
+		maxLoss:     0.05,
+		weights:     CostBreakdown{Position: 1, TimeOverlap: 0.01, Appearance: 0.005, Velocity: 0.002},
+		temperature: 0.005,
+		nullCost:    0.02,
+		workers:     cs.workers,
+	}
+	f := cs.Fundamental()
+	return match(ctx, srList1, srList2, mc, cons, func(sr1, sr2 vannotate.Series) (IPlots, error) {
+		return cs.newEpipolarIplots(f, sr1, sr2)
+	})
+}
+
+func (cs CameraSystem) newEpipolarIplots(f *mat.Dense, sr1, sr2 vannotate.Series) (IPlots, error) {
+	start, end := maxInt(sr1.Start, sr2.Start), minInt(sr1.End, sr2.End)
+	if start > end {
+		return IPlots{}, ErrNoOverlap
+	}
+	m1, ok1 := cs.project(cs.params, 0, sr1.Plots)
+	m2, ok2 := cs.project(cs.params, 1, sr2.Plots)
+
+	ret := IPlots{}
+	ret.sr1, ret.sr2 = sr1, sr2
+	ret.Start = minInt(sr1.Start, sr2.Start)
+	ret.End = maxInt(sr1.End, sr2.End)
+	ret.Size = ret.End - ret.Start + 1
+	ret.Invalid1 = countInvalid(ok1[sr1.Start : sr1.End+1])
+	ret.Invalid2 = countInvalid(ok2[sr2.Start : sr2.End+1])
+
+	ret.Loss = .0
+	for t := start; t <= end; t++ {
+		ret.Loss += epipolarDistance(f, cs.imageCoord(0, sr1.Plots[t]), cs.imageCoord(1, sr2.Plots[t]))
+	}
+	ret.Loss /= float64(end - start + 1)
+
+	ret.obs[0] = newObs(m1, ok1, sr1, ret.Start, ret.Size)
+	ret.obs[1] = newObs(m2, ok2, sr2, ret.Start, ret.Size)
+
+	ret.Plots = mat.NewDense(ret.Size, 3, nil)
+	ret.Disagreement = make([]float64, ret.Size)
+	for t := ret.Start; t <= ret.End; t++ {
+		in1 := sr1.Start <= t && t <= sr1.End
+		in2 := sr2.Start <= t && t <= sr2.End
+		ret.Disagreement[t-ret.Start] = math.NaN()
+		if in1 && in2 && ok1[t] && ok2[t] {
+			diff := mat.NewVecDense(3, nil)
+			diff.SubVec(m1.RowView(t), m2.RowView(t))
+			ret.Disagreement[t-ret.Start] = diff.Norm(2)
+		}
+		if in1 && in2 {
+			if p, ok := cs.triangulate(sr1.Plots[t], sr2.Plots[t]); ok {
+				ret.Plots.SetRow(t-ret.Start, p.RawVector().Data)
+				continue
+			}
+		}
+		if in1 && ok1[t] {
+			ret.Plots.SetRow(t-ret.Start, m1.RawRowView(t))
+		} else if in2 && ok2[t] {
+			ret.Plots.SetRow(t-ret.Start, m2.RawRowView(t))
+		} else {
+			ret.Plots.SetRow(t-ret.Start, []float64{math.NaN(), math.NaN(), math.NaN()})
+		}
+	}
+	return ret, nil
+}
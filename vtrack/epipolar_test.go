@@ -0,0 +1,65 @@
+package vtrack
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// screenOf returns the screen plot of camera cami seeing the world point x, the inverse of ray.
+func (cs CameraSystem) screenOf(cami int, x [3]float64) (vannotate.ScreenPlot, bool) {
+	theta, phi := cs.params.At(0+cami, 0), cs.params.At(3+cami, 0)
+	n, a, b := basis(theta, phi)
+	r, k, c := cs.getConfig(cami)
+	var d [3]float64
+	for i := range d {
+		d[i] = x[i] - c.At(i, 0)
+	}
+	dot := func(u [3]float64) float64 { return u[0]*d[0] + u[1]*d[1] + u[2]*d[2] }
+	dn := dot(n)
+	sp := vannotate.ScreenPlot{P: dot(a) / (k * dn), Q: r * dot(b) / (k * dn)}
+	return sp, dn > 0 && math.Abs(sp.P) < 0.5 && math.Abs(sp.Q) < 0.5
+}
+
+func TestIdenitfyEpipolar(t *testing.T) {
+	cs := synthCameraSystem()
+	// Both cameras look down at the floor between them, camera2 with a narrower view
+	cs.params = mat.NewVecDense(5, []float64{-0.4, -0.31, 0, -0.5 * math.Pi, 0.5 * math.Pi})
+	rng := rand.New(rand.NewSource(3))
+	const n, frames = 5, 200
+	var srLists [2][]vannotate.Series
+	for k := 0; k < n; k++ {
+		x, y := -1.6+0.8*float64(k), -9-rng.Float64()*1.5
+		vx, vy := rng.Float64()*0.004-0.002, rng.Float64()*0.004-0.002
+		for cami := 0; cami < 2; cami++ {
+			sr := vannotate.Series{Conf: 0.9, End: frames - 1, Plots: make([]vannotate.ScreenPlot, frames)}
+			for f := 0; f < frames; f++ {
+				sp, ok := cs.screenOf(cami, [3]float64{x + vx*float64(f), y + vy*float64(f), 1.0})
+				if !ok {
+					t.Fatalf("person %d is out of sight of camera%d", k, cami+1)
+				}
+				sp.P += rng.NormFloat64() * 0.001
+				sp.Q += rng.NormFloat64() * 0.001
+				sr.Plots[f] = sp
+			}
+			srLists[cami] = append(srLists[cami], sr)
+		}
+	}
+
+	ipList, err := cs.IdenitfyEpipolar(context.Background(), srLists[0], srLists[1], Constraints{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipList) != n {
+		t.Fatalf("got %d matches, want %d", len(ipList), n)
+	}
+	for _, ip := range ipList {
+		if ip.Series1[0] != ip.Series2[0] {
+			t.Errorf("matched series %v with %v", ip.Series1, ip.Series2)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package vtrack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+
+	"github.com/payashi/vannotate"
+)
+
+var (
+	// ErrNotFound is returned for a file which does not exist; it is vannotate.ErrNotFound.
+	ErrNotFound = vannotate.ErrNotFound
+	// ErrNoOverlap is returned for series which are never seen at the same time.
+	ErrNoOverlap = errors.New("no overlap")
+	// ErrInvalidCalibration is returned for a camera system which cannot be loaded or tuned.
+	ErrInvalidCalibration = errors.New("invalid calibration")
+	// ErrInvalidCamera is returned for a camera index other than 0 or 1.
+	ErrInvalidCamera = errors.New("invalid camera")
+	// ErrConflictingConstraints is returned for must-links which cannot all hold.
+	ErrConflictingConstraints = errors.New("conflicting constraints")
+)
+
+func checkCamera(cami int) error {
+	if cami < 0 || 1 < cami {
+		return fmt.Errorf("%w %d", ErrInvalidCamera, cami)
+	}
+	return nil
+}
+
+// readFile reads filePath, reporting a missing file as ErrNotFound.
+func readFile(filePath string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", filePath, ErrNotFound)
+	}
+	return b, err
+}
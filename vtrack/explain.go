@@ -0,0 +1,119 @@
+package vtrack
+
+import (
+	"math"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+const frameDt = 0.1 // seconds per frame of vannotate series
+
+// Components of a matching cost before weighting
+type CostBreakdown struct {
+	Position    float64 `json:"position"`   // Loss
+	TimeOverlap float64 `json:"overlap"`    // fraction of the shorter side not seen by the other camera
+	Appearance  float64 `json:"appearance"` // distance of appearance descriptors, 0.5 if unknown
+	Velocity    float64 `json:"velocity"`   // m/s; mean difference of the velocities seen by each camera
+}
+
+func (cb CostBreakdown) weighted(w CostBreakdown) float64 {
+	return w.Position*cb.Position + w.TimeOverlap*cb.TimeOverlap +
+		w.Appearance*cb.Appearance + w.Velocity*cb.Velocity
+}
+
+// Candidate which competed with a match for one of its series
+type Alternative struct {
+	Series1    []int   `json:"series1"`
+	Series2    []int   `json:"series2"`
+	Fragments1 []int   `json:"fragments1,omitempty"`
+	Fragments2 []int   `json:"fragments2,omitempty"`
+	Cost       float64 `json:"cost"`
+}
+
+type matchConfig struct {
+	maxLoss     float64
+	weights     CostBreakdown
+	temperature float64 // cost by which the score of a candidate drops by a factor of e
+	nullCost    float64 // cost at which a candidate scores as high as no match
+	workers     int     // building the candidates, see SetParallelism
+}
+
+// setCost explains the cost of ip, which integrates srs1 and srs2, by its components.
+func (ip *IPlots) setCost(srs1, srs2 []vannotate.Series, mc matchConfig) {
+	ip.Breakdown = CostBreakdown{
+		Position:    ip.Loss,
+		TimeOverlap: timeOverlap(srs1, srs2),
+		Appearance:  attributeDistance(mergedAttributes(srs1), mergedAttributes(srs2)),
+		Velocity:    velocityDifference(ip.obs),
+	}
+	ip.Cost = ip.Breakdown.weighted(mc.weights)
+}
+
+// explain scores ip against the candidates sharing a series with it and against "no match".
+// Score is the softmax of their costs scaled by the temperature, with "no match" at nullCost,
+// and ScoreRatio compares ip with the cheapest of the others on the same scale.
+// Both constants are picked by hand, so Score and ScoreRatio rank a match against its alternatives
+// but are not a calibrated probability or likelihood ratio.
+func (ip *IPlots) explain(alts []Alternative, mc matchConfig) {
+	// Relative to ip to keep the exponentials in range
+	rel := func(cost float64) float64 { return math.Exp((ip.Cost - cost) / mc.temperature) }
+	sum := 1 + rel(mc.nullCost)
+	best := mc.nullCost
+	ip.RunnerUp = nil
+	for k := range alts {
+		sum += rel(alts[k].Cost)
+		if alts[k].Cost < best {
+			best = alts[k].Cost
+			ip.RunnerUp = &alts[k]
+		}
+	}
+	ip.Score = 1 / sum
+	ip.ScoreRatio = 1 / rel(best)
+}
+
+// Fraction of the frames of the shorter side which the other side does not cover.
+// Series of one side must not overlap each other in time.
+func timeOverlap(srs1, srs2 []vannotate.Series) float64 {
+	n1, n2, overlap := 0, 0, 0
+	for _, sr := range srs1 {
+		n1 += sr.End - sr.Start + 1
+	}
+	for _, sr := range srs2 {
+		n2 += sr.End - sr.Start + 1
+	}
+	for _, sr1 := range srs1 {
+		for _, sr2 := range srs2 {
+			overlap += maxInt(minInt(sr1.End, sr2.End)-maxInt(sr1.Start, sr2.Start)+1, 0)
+		}
+	}
+	return 1 - float64(overlap)/float64(maxInt(minInt(n1, n2), 1))
+}
+
+// Mean norm of the difference between the velocities of both cameras' projections,
+// taken over lag frames to smooth out the detection noise.
+func velocityDifference(obs [2]*mat.Dense) float64 {
+	const lag = 5
+	if obs[0] == nil || obs[1] == nil {
+		return 0
+	}
+	n, _ := obs[0].Dims()
+	sum, count := .0, 0
+	for t := lag; t < n; t++ {
+		if math.IsNaN(obs[0].At(t, 0)) || math.IsNaN(obs[0].At(t-lag, 0)) ||
+			math.IsNaN(obs[1].At(t, 0)) || math.IsNaN(obs[1].At(t-lag, 0)) {
+			continue
+		}
+		d2 := .0
+		for k := 0; k < 3; k++ {
+			dv := (obs[0].At(t, k) - obs[0].At(t-lag, k)) - (obs[1].At(t, k) - obs[1].At(t-lag, k))
+			d2 += dv * dv
+		}
+		sum += math.Sqrt(d2) / (lag * frameDt)
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
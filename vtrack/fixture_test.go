@@ -0,0 +1,59 @@
+package vtrack
+
+import (
+	"math/rand"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Homographies of the synthetic scene: 20 m across the screen,
+// with camera2 looking 2 m further along y than camera1
+var synthH = [2][]float64{
+	{20, 0, 0, 0, 20, 0, 0, 0, 1},
+	{20, 0, 0, 0, 20, 2, 0, 0, 1},
+}
+
+func synthHomographySystem() *HomographySystem {
+	return NewHomographySystem(mat.NewDense(3, 3, synthH[0]), mat.NewDense(3, 3, synthH[1]), 1.7)
+}
+
+// Cameras of the example session
+func synthCameraSystem() *CameraSystem {
+	return NewCameraSystem(Config{
+		K1: 1.32, K2: 0.467,
+		R1: 16. / 9., R2: 16. / 9.,
+		C1: *mat.NewVecDense(3, []float64{0, 0, 4.028}),
+		C2: *mat.NewVecDense(3, []float64{0, -18.97, 3.904}),
+	})
+}
+
+// synthSeries returns n persons walking in straight lines over frames frames of the synthetic scene.
+// Person k is seen as srLists[0][k] by camera1 and srLists[1][k] by camera2, with noise on the screen.
+func synthSeries(n, frames int, seed int64) [2][]vannotate.Series {
+	rng := rand.New(rand.NewSource(seed))
+	var ret [2][]vannotate.Series
+	for k := 0; k < n; k++ {
+		x, y := rng.Float64()*10-5, rng.Float64()*6-8
+		vx, vy := rng.Float64()*0.06-0.03, rng.Float64()*0.02-0.01
+		start := rng.Intn(frames / 4)
+		end := frames - 1 - rng.Intn(frames/4)
+		for cami := 0; cami < 2; cami++ {
+			sr := vannotate.Series{
+				Conf:  0.9,
+				Start: start,
+				End:   end,
+				Plots: make([]vannotate.ScreenPlot, frames),
+			}
+			for t := start; t <= end; t++ {
+				wx, wy := x+vx*float64(t-start), y+vy*float64(t-start)
+				sr.Plots[t] = vannotate.ScreenPlot{
+					P: wx/20 + rng.NormFloat64()*0.002,
+					Q: (wy-synthH[cami][5])/20 + rng.NormFloat64()*0.002,
+				}
+			}
+			ret[cami] = append(ret[cami], sr)
+		}
+	}
+	return ret
+}
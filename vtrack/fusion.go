@@ -0,0 +1,62 @@
+package vtrack
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Standard deviation of a ground position p seen by a camera at c.
+// A screen error turns into an angular error scaled by k, which grows with
+// the distance and with grazing rays, and shrinks with the detection confidence.
+func projectionSigma(c *mat.VecDense, k float64, p []float64, conf float32) float64 {
+	const (
+		screenErr  = 0.01 // typical error of a detection in screen units
+		minSinElev = 0.05 // clamp for nearly horizontal rays
+		minConf    = 0.01
+	)
+	dx, dy, dz := p[0]-c.At(0, 0), p[1]-c.At(1, 0), p[2]-c.At(2, 0)
+	dist := math.Sqrt(dx*dx + dy*dy + dz*dz)
+	sinElev := math.Max(math.Abs(dz)/dist, minSinElev)
+	return k * screenErr * dist / sinElev / math.Sqrt(math.Max(float64(conf), minConf))
+}
+
+func (cs CameraSystem) Sigma(cami int, p []float64, conf float32) (float64, error) {
+	if err := checkCamera(cami); err != nil {
+		return 0, err
+	}
+	_, k, c := cs.getConfig(cami)
+	return projectionSigma(&c, k, p, conf), nil
+}
+
+// Sigma of a homography model only knows the geometry when camera positions are set.
+func (hs HomographySystem) Sigma(cami int, p []float64, conf float32) (float64, error) {
+	if err := checkCamera(cami); err != nil {
+		return 0, err
+	}
+	if hs.center[cami] == nil {
+		return 1 / math.Sqrt(math.Max(float64(conf), 0.01)), nil
+	}
+	return projectionSigma(hs.center[cami], 1, p, conf), nil
+}
+
+// fuse combines the projections of both cameras by inverse-variance weighting
+// and returns the fused position and the distance between the two projections.
+func fuse(m CameraModel, p1, p2 []float64, conf1, conf2 float32) ([]float64, float64, error) {
+	s1, err := m.Sigma(0, p1, conf1)
+	if err != nil {
+		return nil, 0, err
+	}
+	s2, err := m.Sigma(1, p2, conf2)
+	if err != nil {
+		return nil, 0, err
+	}
+	w1, w2 := 1/(s1*s1), 1/(s2*s2)
+	ret := make([]float64, 3)
+	dist := .0
+	for i := 0; i < 3; i++ {
+		ret[i] = (w1*p1[i] + w2*p2[i]) / (w1 + w2)
+		dist += (p1[i] - p2[i]) * (p1[i] - p2[i])
+	}
+	return ret, math.Sqrt(dist), nil
+}
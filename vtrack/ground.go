@@ -0,0 +1,236 @@
+package vtrack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/floats"
+)
+
+// Ground surface people walk on
+type Ground interface {
+	// Height returns the elevation of the ground at (x, y)
+	Height(x, y float64) float64
+}
+
+// Planar polygon; its plane passes through the first three vertices
+type Polygon struct {
+	Vertices [][3]float64 `json:"vertices"`
+}
+
+// Ground made of planar polygons such as platforms and ramps
+type PlanarGround struct {
+	Polygons []Polygon `json:"polygons"`
+	Default  float64   `json:"default"` // height outside every polygon
+}
+
+func (pg PlanarGround) Height(x, y float64) float64 {
+	// Later polygons take precedence, so a platform can be put on a larger floor
+	for i := len(pg.Polygons) - 1; i >= 0; i-- {
+		if h, ok := pg.Polygons[i].height(x, y); ok {
+			return h
+		}
+	}
+	return pg.Default
+}
+
+func (pl Polygon) height(x, y float64) (float64, bool) {
+	vs := pl.Vertices
+	if len(vs) < 3 || !pl.contains(x, y) {
+		return 0, false
+	}
+	// Normal of the plane through the first three vertices
+	ux, uy, uz := vs[1][0]-vs[0][0], vs[1][1]-vs[0][1], vs[1][2]-vs[0][2]
+	wx, wy, wz := vs[2][0]-vs[0][0], vs[2][1]-vs[0][1], vs[2][2]-vs[0][2]
+	nx, ny, nz := uy*wz-uz*wy, uz*wx-ux*wz, ux*wy-uy*wx
+	if nz == 0 {
+		return vs[0][2], true
+	}
+	return vs[0][2] - (nx*(x-vs[0][0])+ny*(y-vs[0][1]))/nz, true
+}
+
+func (pl Polygon) contains(x, y float64) bool {
+	vs := pl.Vertices
+	ret := false
+	for i, j := 0, len(vs)-1; i < len(vs); j, i = i, i+1 {
+		if (vs[i][1] > y) != (vs[j][1] > y) &&
+			x < (vs[j][0]-vs[i][0])*(y-vs[i][1])/(vs[j][1]-vs[i][1])+vs[i][0] {
+			ret = !ret
+		}
+	}
+	return ret
+}
+
+// Gridded elevation map; Rows[iy][ix] is the height at (X0 + ix*Cell, Y0 + iy*Cell)
+type HeightMap struct {
+	X0      float64     `json:"x0"`
+	Y0      float64     `json:"y0"`
+	Cell    float64     `json:"cell"`
+	Rows    [][]float64 `json:"rows"`
+	Default float64     `json:"default"` // height outside the grid
+}
+
+func (hm HeightMap) Height(x, y float64) float64 {
+	ny := len(hm.Rows)
+	if ny == 0 || hm.Cell <= 0 {
+		return hm.Default
+	}
+	nx := len(hm.Rows[0])
+	fx, fy := (x-hm.X0)/hm.Cell, (y-hm.Y0)/hm.Cell
+	if fx < 0 || fy < 0 || fx > float64(nx-1) || fy > float64(ny-1) {
+		return hm.Default
+	}
+
+	// Bilinear interpolation
+	ix, iy := minInt(int(fx), nx-2), minInt(int(fy), ny-2)
+	if nx == 1 || ny == 1 {
+		return hm.Rows[maxInt(iy, 0)][maxInt(ix, 0)]
+	}
+	tx, ty := fx-float64(ix), fy-float64(iy)
+	z00, z10 := hm.Rows[iy][ix], hm.Rows[iy][ix+1]
+	z01, z11 := hm.Rows[iy+1][ix], hm.Rows[iy+1][ix+1]
+	return (1-ty)*((1-tx)*z00+tx*z10) + ty*((1-tx)*z01+tx*z11)
+}
+
+// LoadGround loads a ground model from a json file.
+// The file has "type" of either "planes" (PlanarGround) or "heightmap" (HeightMap).
+func LoadGround(filePath string) (Ground, error) {
+	b, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	switch v.Type {
+	case "planes":
+		pg := PlanarGround{}
+		err = json.Unmarshal(b, &pg)
+		return pg, err
+	case "heightmap":
+		hm := HeightMap{}
+		err = json.Unmarshal(b, &hm)
+		if err == nil && len(hm.Rows) > 0 {
+			for _, row := range hm.Rows {
+				if len(row) != len(hm.Rows[0]) {
+					return nil, errors.New("heightmap: rows should have the same length")
+				}
+			}
+		}
+		return hm, err
+	default:
+		return nil, fmt.Errorf("ground: unknown type %q", v.Type)
+	}
+}
+
+// Ground which knows the range of its heights
+type boundedGround interface {
+	Bounds() (min, max float64)
+}
+
+func (pg PlanarGround) Bounds() (float64, float64) {
+	lo, hi := pg.Default, pg.Default
+	for _, pl := range pg.Polygons {
+		for _, v := range pl.Vertices {
+			lo, hi = math.Min(lo, v[2]), math.Max(hi, v[2])
+		}
+	}
+	return lo, hi
+}
+
+func (hm HeightMap) Bounds() (float64, float64) {
+	lo, hi := hm.Default, hm.Default
+	for _, row := range hm.Rows {
+		for _, z := range row {
+			lo, hi = math.Min(lo, z), math.Max(hi, z)
+		}
+	}
+	return lo, hi
+}
+
+// Range of ground heights, if known
+type groundRange struct {
+	min, max float64
+	ok       bool
+}
+
+func getGroundRange(g Ground) groundRange {
+	if bg, ok := g.(boundedGround); ok {
+		hmin, hmax := bg.Bounds()
+		return groundRange{hmin, hmax, true}
+	}
+	return groundRange{}
+}
+
+// intersectGround finds the smallest t > 0 where c + t*d is z0 above the ground.
+func intersectGround(g Ground, gr groundRange, c, d [3]float64, z0 float64) (float64, bool) {
+	const (
+		maxDist = 200. // give up beyond this distance along the ray
+		step    = 0.25 // marching step along the ray
+		tol     = 1e-6
+	)
+	f := func(t float64) float64 {
+		x, y, z := c[0]+t*d[0], c[1]+t*d[1], c[2]+t*d[2]
+		return z - g.Height(x, y) - z0
+	}
+
+	norm := floats.Norm(d[:], 2)
+	if norm == 0 {
+		return 0, false
+	}
+	lo, hi := 0., maxDist/norm
+	// Only the part of the ray between the lowest and the highest ground can hit it
+	if gr.ok {
+		hmin, hmax := gr.min, gr.max
+		dz := d[2]
+		if dz >= 0 {
+			if c[2] > hmax+z0 {
+				return 0, false
+			}
+		} else {
+			lo = math.Max(lo, (hmax+z0-c[2])/dz)
+			// Pad by tol so that rounding does not hide the crossing at the lowest ground
+			hi = math.Min(hi, (hmin+z0-c[2])/dz+tol)
+		}
+	}
+	if lo > hi || f(lo) < 0 || (lo == 0 && f(lo) == 0) {
+		// The camera itself is below the surface
+		return 0, false
+	}
+
+	// March along the ray for the first sign change
+	dt := step / norm
+	for t := lo; t < hi+dt; t += dt {
+		nt := math.Min(t+dt, hi)
+		if f(nt) > 0 {
+			if nt == hi {
+				break
+			}
+			continue
+		}
+		// Bisect within [t, nt]
+		a, b := t, nt
+		for b-a > tol {
+			mid := (a + b) / 2
+			if f(mid) > 0 {
+				a = mid
+			} else {
+				b = mid
+			}
+		}
+		return b, true
+	}
+	return 0, false
+}
+
+// SetGround sets the ground surface rays are intersected with.
+// A nil ground means the flat floor at height 0.
+func (cs *CameraSystem) SetGround(g Ground) {
+	cs.ground = g
+}
@@ -0,0 +1,200 @@
+package vtrack
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/payashi/vannotate"
+)
+
+// Identity made of several series from each camera
+type group struct {
+	members [2][]int
+	loss    float64
+	forced  bool // whether it contains a must-link
+}
+
+func (cs CameraSystem) IdenitfyMany(ctx context.Context, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	return identifyMany(ctx, cs, srList1, srList2, cons)
+}
+
+func (hs HomographySystem) IdenitfyMany(ctx context.Context, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	return identifyMany(ctx, hs, srList1, srList2, cons)
+}
+
+// identifyMany lets one identity contain several series from each camera
+// as long as the series of one camera do not overlap each other in time.
+// Pairs are merged in ascending order of their cost, and a merge is kept
+// only if the loss of the whole identity stays below maxLoss and does not
+// grow by more than MergeSlack over the best group it joins.
+// Each identity is scored against the pairs linking its series to others.
+// Pairs required by cons are merged first without the bounds on the loss,
+// and no identity may contain a pair it forbids; a required pair which cannot
+// be built or merged is an error.
+func identifyMany(ctx context.Context, m CameraModel, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	const MergeSlack float64 = 1
+	mc := geometricMatch
+	mc.workers = parallelismOf(m)
+	rels := cons.relations(srList1, srList2)
+
+	type edge struct {
+		i, j       int
+		loss, cost float64
+		forced     bool
+	}
+	n2 := len(srList2)
+	builds, err := buildPairs(ctx, mc.workers, srList1, srList2, rels, func(sr1, sr2 vannotate.Series) (IPlots, error) {
+		return newIplots(m, sr1, sr2)
+	})
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]edge, 0)
+	for i, sr1 := range srList1 {
+		for j, sr2 := range srList2 {
+			if rels[i][j] == cannotLink {
+				continue
+			}
+			forced := rels[i][j] == mustLink
+			ip, err := builds[i*n2+j].ip, builds[i*n2+j].err
+			if err != nil {
+				if forced {
+					return nil, fmt.Errorf("must-link %v-%v: %w", fragmentsOf(srList1, []int{i}), fragmentsOf(srList2, []int{j}), err)
+				}
+				continue
+			}
+			if ip.Loss > mc.maxLoss && !forced {
+				continue
+			}
+			ip.setCost([]vannotate.Series{sr1}, []vannotate.Series{sr2}, mc)
+			edges = append(edges, edge{i, j, ip.Loss, ip.Cost, forced})
+		}
+	}
+	sort.SliceStable(edges, func(a, b int) bool {
+		if edges[a].forced != edges[b].forced {
+			return edges[a].forced
+		}
+		return edges[a].cost < edges[b].cost
+	})
+
+	// Group of each series, nil if it is not matched yet
+	owners := [2][]*group{make([]*group, len(srList1)), make([]*group, len(srList2))}
+	for _, e := range edges {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		g1, g2 := owners[0][e.i], owners[1][e.j]
+		if g1 != nil && g1 == g2 {
+			continue
+		}
+		merged := &group{}
+		bound := math.Inf(1)
+		for _, g := range []*group{g1, g2} {
+			if g == nil {
+				continue
+			}
+			bound = math.Min(bound, g.loss)
+			merged.members[0] = append(merged.members[0], g.members[0]...)
+			merged.members[1] = append(merged.members[1], g.members[1]...)
+		}
+		if g1 == nil {
+			merged.members[0] = append(merged.members[0], e.i)
+		}
+		if g2 == nil {
+			merged.members[1] = append(merged.members[1], e.j)
+		}
+		if overlaps(srList1, merged.members[0]) || overlaps(srList2, merged.members[1]) ||
+			forbids(rels, merged) {
+			if e.forced {
+				return nil, fmt.Errorf("must-link %v-%v conflicts with its group %v-%v: %w",
+					fragmentsOf(srList1, []int{e.i}), fragmentsOf(srList2, []int{e.j}),
+					fragmentsOf(srList1, merged.members[0]), fragmentsOf(srList2, merged.members[1]), ErrConflictingConstraints)
+			}
+			continue
+		}
+		ip, err := newGroupIplots(m, pick(srList1, merged.members[0]), pick(srList2, merged.members[1]))
+		if err != nil && e.forced {
+			return nil, fmt.Errorf("must-link %v-%v: %w", fragmentsOf(srList1, []int{e.i}), fragmentsOf(srList2, []int{e.j}), err)
+		}
+		if err != nil || (!e.forced && (ip.Loss > mc.maxLoss || ip.Loss > bound+MergeSlack)) {
+			continue
+		}
+		merged.forced = e.forced || (g1 != nil && g1.forced) || (g2 != nil && g2.forced)
+		merged.loss = ip.Loss
+		for cami := 0; cami < 2; cami++ {
+			for _, idx := range merged.members[cami] {
+				owners[cami][idx] = merged
+			}
+		}
+	}
+
+	ret := make([]IPlots, 0)
+	seen := make(map[*group]bool)
+	// Every group has a member in both cameras, so looking at camera1 is enough
+	for _, g := range owners[0] {
+		if g == nil || seen[g] {
+			continue
+		}
+		seen[g] = true
+		sort.Ints(g.members[0])
+		sort.Ints(g.members[1])
+		ip, err := newGroupIplots(m, pick(srList1, g.members[0]), pick(srList2, g.members[1]))
+		if err != nil {
+			continue
+		}
+		ip.i, ip.j = g.members[0][0], g.members[1][0]
+		ip.Series1, ip.Series2 = g.members[0], g.members[1]
+		ip.Fragments1, ip.Fragments2 = fragmentsOf(srList1, g.members[0]), fragmentsOf(srList2, g.members[1])
+		ip.setCost(pick(srList1, g.members[0]), pick(srList2, g.members[1]), mc)
+		ip.Forced = g.forced
+		alts := make([]Alternative, 0)
+		for _, e := range edges {
+			if contains(g.members[0], e.i) != contains(g.members[1], e.j) {
+				alts = append(alts, Alternative{
+					Series1: []int{e.i}, Series2: []int{e.j},
+					Fragments1: fragmentsOf(srList1, []int{e.i}), Fragments2: fragmentsOf(srList2, []int{e.j}),
+					Cost: e.cost,
+				})
+			}
+		}
+		ip.explain(alts, mc)
+		ret = append(ret, ip)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Cost < ret[j].Cost })
+	return ret, nil
+}
+
+func pick(srList []vannotate.Series, idxs []int) []vannotate.Series {
+	ret := make([]vannotate.Series, len(idxs))
+	for k, idx := range idxs {
+		ret[k] = srList[idx]
+	}
+	return ret
+}
+
+// Whether the group contains a pair forbidden by rels
+func forbids(rels [][]int, g *group) bool {
+	for _, i := range g.members[0] {
+		for _, j := range g.members[1] {
+			if rels[i][j] == cannotLink {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether any two of the series overlap in time
+func overlaps(srList []vannotate.Series, idxs []int) bool {
+	for a := 0; a < len(idxs); a++ {
+		for b := a + 1; b < len(idxs); b++ {
+			sr1, sr2 := srList[idxs[a]], srList[idxs[b]]
+			if maxInt(sr1.Start, sr2.Start) <= minInt(sr1.End, sr2.End) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package vtrack
+
+import (
+	"math"
+	"sort"
+
+	"github.com/payashi/vannotate"
+)
+
+type HandoffConfig struct {
+	Dt       float64 // seconds per frame
+	MaxGap   int     // frames between the exit and the entry at most
+	Window   int     // frames used to estimate the exit velocity
+	PosNoise float64 // m; uncertainty of the exit position
+	VelNoise float64 // m/s; uncertainty of the exit velocity, which grows with the gap
+	Gate     float64 // Mahalanobis distance at which a candidate is as likely as no match
+	MinProb  float64
+}
+
+// Link of a track leaving one camera to a track entering the other
+type Handoff struct {
+	From       int        `json:"from"` // camera of the exiting track
+	I          int        `json:"i"`    // series of the exiting track
+	J          int        `json:"j"`    // series of the entering track
+	ExitFrame  int        `json:"exitframe"`
+	EntryFrame int        `json:"entryframe"`
+	Predicted  [2]float64 `json:"predicted"` // predicted position at EntryFrame
+	Prob       float64    `json:"prob"`
+	// Indices given by vannotate.GetSeries of the series making up the exiting and the entering track
+	Fragments [2][]int `json:"fragments"`
+}
+
+func (cs CameraSystem) Handoff(srList1, srList2 []vannotate.Series, hconfig HandoffConfig) ([]Handoff, error) {
+	return handoff(cs, srList1, srList2, hconfig)
+}
+
+func (hs HomographySystem) Handoff(srList1, srList2 []vannotate.Series, hconfig HandoffConfig) ([]Handoff, error) {
+	return handoff(hs, srList1, srList2, hconfig)
+}
+
+// handoff links tracks which leave one camera to tracks which later enter the other.
+// The exit position is extrapolated with a constant velocity whose uncertainty grows over the gap,
+// and exits which never reach the ground covered by the other camera are not linked.
+// Prob is the likelihood of a candidate normalized over all candidates and "no match".
+func handoff(m CameraModel, srList1, srList2 []vannotate.Series, hconfig HandoffConfig) ([]Handoff, error) {
+	srLists := [2][]vannotate.Series{srList1, srList2}
+	covers := [2]Polygon{}
+	heads := [2][]fragmentEnd{}
+	tails := [2][]fragmentEnd{}
+	for cami := 0; cami < 2; cami++ {
+		var err error
+		if covers[cami], err = coverage(m, cami); err != nil {
+			return nil, err
+		}
+		heads[cami] = make([]fragmentEnd, len(srLists[cami]))
+		tails[cami] = make([]fragmentEnd, len(srLists[cami]))
+		for i, sr := range srLists[cami] {
+			pm, valid, err := m.Project(cami, sr.Plots)
+			if err != nil {
+				return nil, err
+			}
+			heads[cami][i] = getFragmentEnd(pm, valid, sr, hconfig.Window, hconfig.Dt, true)
+			tails[cami][i] = getFragmentEnd(pm, valid, sr, hconfig.Window, hconfig.Dt, false)
+		}
+	}
+
+	cands := make([]Handoff, 0)
+	for from := 0; from < 2; from++ {
+		to := 1 - from
+		for i, tail := range tails[from] {
+			if !tail.ok {
+				continue
+			}
+			// When the extrapolated track first reaches the other camera's coverage
+			entry := -1
+			for k := 1; k <= hconfig.MaxGap; k++ {
+				elapsed := float64(k) * hconfig.Dt
+				if covers[to].contains(tail.pos[0]+tail.vel[0]*elapsed, tail.pos[1]+tail.vel[1]*elapsed) {
+					entry = tail.t + k
+					break
+				}
+			}
+			if entry == -1 {
+				continue
+			}
+
+			links := make([]Handoff, 0)
+			sum := math.Exp(-hconfig.Gate * hconfig.Gate / 2) // no match
+			for j, head := range heads[to] {
+				if !head.ok || head.t <= tail.t || head.t-tail.t > hconfig.MaxGap {
+					continue
+				}
+				elapsed := float64(head.t-tail.t) * hconfig.Dt
+				px := tail.pos[0] + tail.vel[0]*elapsed
+				py := tail.pos[1] + tail.vel[1]*elapsed
+				sigma := math.Hypot(hconfig.PosNoise, hconfig.VelNoise*elapsed)
+				// Entering much earlier or later than predicted is also unlikely
+				sigmaT := math.Max(float64(entry-tail.t), 1) / 2
+				d2 := (math.Pow(head.pos[0]-px, 2)+math.Pow(head.pos[1]-py, 2))/(sigma*sigma) +
+					math.Pow(float64(head.t-entry), 2)/(sigmaT*sigmaT)
+				l := math.Exp(-d2 / 2)
+				sum += l
+				links = append(links, Handoff{
+					From: from, I: i, J: j,
+					ExitFrame: tail.t, EntryFrame: head.t,
+					Predicted: [2]float64{px, py},
+					Prob:      l,
+					Fragments: [2][]int{seriesIDs(srLists[from][i], i), seriesIDs(srLists[to][j], j)},
+				})
+			}
+			for _, link := range links {
+				link.Prob /= sum
+				if link.Prob >= hconfig.MinProb {
+					cands = append(cands, link)
+				}
+			}
+		}
+	}
+
+	// Each track leaves and enters at most once
+	sort.SliceStable(cands, func(a, b int) bool { return cands[a].Prob > cands[b].Prob })
+	usedExits := [2]map[int]bool{{}, {}}
+	usedEntries := [2]map[int]bool{{}, {}}
+	ret := make([]Handoff, 0)
+	for _, c := range cands {
+		to := 1 - c.From
+		if usedExits[c.From][c.I] || usedEntries[to][c.J] {
+			continue
+		}
+		usedExits[c.From][c.I] = true
+		usedEntries[to][c.J] = true
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// coverage returns the ground area seen by camera cami as a polygon
+// along the bottom half of the screen, lowered until its top edge hits the ground.
+func coverage(m CameraModel, cami int) (Polygon, error) {
+	const nsteps = 4
+	top := 0.
+	for ; top > -0.5; top -= 0.05 {
+		_, valid, err := m.Project(cami, []vannotate.ScreenPlot{{P: -0.5, Q: top}, {P: +0.5, Q: top}})
+		if err != nil {
+			return Polygon{}, err
+		}
+		if valid[0] && valid[1] {
+			break
+		}
+	}
+
+	// Counterclockwise on the screen: bottom, right, top, left
+	border := make([]vannotate.ScreenPlot, 0, 4*nsteps)
+	for k := 0; k < nsteps; k++ {
+		r := float64(k) / nsteps
+		border = append(border, vannotate.ScreenPlot{P: -0.5 + r, Q: -0.5})
+	}
+	for k := 0; k < nsteps; k++ {
+		r := float64(k) / nsteps
+		border = append(border, vannotate.ScreenPlot{P: +0.5, Q: -0.5 + r*(top+0.5)})
+	}
+	for k := 0; k < nsteps; k++ {
+		r := float64(k) / nsteps
+		border = append(border, vannotate.ScreenPlot{P: +0.5 - r, Q: top})
+	}
+	for k := 0; k < nsteps; k++ {
+		r := float64(k) / nsteps
+		border = append(border, vannotate.ScreenPlot{P: -0.5, Q: top - r*(top+0.5)})
+	}
+
+	pm, valid, err := m.Project(cami, border)
+	if err != nil {
+		return Polygon{}, err
+	}
+	ret := Polygon{}
+	for k := range border {
+		if valid[k] {
+			ret.Vertices = append(ret.Vertices, [3]float64{pm.At(k, 0), pm.At(k, 1), pm.At(k, 2)})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,239 @@
+package vtrack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Pair of a screen plot and its known position on the ground
+type Correspondence struct {
+	Screen vannotate.ScreenPlot
+	X, Y   float64
+}
+
+// Planar camera model with an image-to-ground homography per camera
+type HomographySystem struct {
+	h       [2]*mat.Dense
+	z0      float64          // height of the plane the homographies map onto
+	center  [2]*mat.VecDense // optional camera positions, only for plotting
+	workers int              // set by SetParallelism
+}
+
+func NewHomographySystem(h1, h2 *mat.Dense, z0 float64) *HomographySystem {
+	hs := new(HomographySystem)
+	hs.h = [2]*mat.Dense{h1, h2}
+	hs.z0 = z0
+	return hs
+}
+
+// SetPositions sets camera positions shown in bird's-eye plots.
+func (hs *HomographySystem) SetPositions(c1, c2 *mat.VecDense) {
+	hs.center = [2]*mat.VecDense{c1, c2}
+}
+
+// EstimateHomography estimates an image-to-ground homography from four or more
+// correspondences with the normalized direct linear transform.
+func EstimateHomography(cors []Correspondence) (*mat.Dense, error) {
+	n := len(cors)
+	if n < 4 {
+		return nil, fmt.Errorf("homography: %w: need at least 4 correspondences", ErrInvalidCalibration)
+	}
+	src := make([][2]float64, n)
+	dst := make([][2]float64, n)
+	for i, c := range cors {
+		src[i] = [2]float64{c.Screen.P, c.Screen.Q}
+		dst[i] = [2]float64{c.X, c.Y}
+	}
+	ts, ns := normalizePoints(src)
+	td, nd := normalizePoints(dst)
+
+	a := mat.NewDense(2*n, 9, nil)
+	for i := 0; i < n; i++ {
+		u, v := ns[i][0], ns[i][1]
+		x, y := nd[i][0], nd[i][1]
+		a.SetRow(2*i, []float64{u, v, 1, 0, 0, 0, -x * u, -x * v, -x})
+		a.SetRow(2*i+1, []float64{0, 0, 0, u, v, 1, -y * u, -y * v, -y})
+	}
+	var svd mat.SVD
+	if ok := svd.Factorize(a, mat.SVDFull); !ok {
+		return nil, fmt.Errorf("homography: %w: svd failed", ErrInvalidCalibration)
+	}
+	var vt mat.Dense
+	svd.VTo(&vt)
+	hn := mat.NewDense(3, 3, nil)
+	for i := 0; i < 9; i++ {
+		hn.Set(i/3, i%3, vt.At(i, 8))
+	}
+
+	// Denormalize: H = Td^-1 * Hn * Ts
+	var tdinv mat.Dense
+	if err := tdinv.Inverse(td); err != nil {
+		return nil, err
+	}
+	h := mat.NewDense(3, 3, nil)
+	h.Product(&tdinv, hn, ts)
+	if s := h.At(2, 2); s != 0 {
+		h.Scale(1/s, h)
+	}
+	return h, nil
+}
+
+// Translate points to the origin and scale them so that the mean distance is sqrt(2).
+func normalizePoints(pts [][2]float64) (*mat.Dense, [][2]float64) {
+	n := float64(len(pts))
+	var mx, my float64
+	for _, p := range pts {
+		mx += p[0] / n
+		my += p[1] / n
+	}
+	dist := .0
+	for _, p := range pts {
+		dist += math.Hypot(p[0]-mx, p[1]-my) / n
+	}
+	s := 1.
+	if dist > 0 {
+		s = math.Sqrt2 / dist
+	}
+	ret := make([][2]float64, len(pts))
+	for i, p := range pts {
+		ret[i] = [2]float64{s * (p[0] - mx), s * (p[1] - my)}
+	}
+	t := mat.NewDense(3, 3, []float64{
+		s, 0, -s * mx,
+		0, s, -s * my,
+		0, 0, 1,
+	})
+	return t, ret
+}
+
+// Homography returns the image-to-ground homography of camera cami
+// induced by the pinhole model on the Z0 plane.
+func (cs CameraSystem) Homography(cami int) (*mat.Dense, error) {
+	screen := []vannotate.ScreenPlot{
+		{P: -0.5, Q: -0.5}, {P: +0.5, Q: -0.5},
+		{P: +0.5, Q: -0.1}, {P: -0.5, Q: -0.1},
+		{P: 0, Q: -0.3},
+	}
+	m, valid, err := cs.Project(cami, screen)
+	if err != nil {
+		return nil, err
+	}
+	cors := make([]Correspondence, 0, len(screen))
+	for i, sp := range screen {
+		if valid[i] {
+			cors = append(cors, Correspondence{Screen: sp, X: m.At(i, 0), Y: m.At(i, 1)})
+		}
+	}
+	return EstimateHomography(cors)
+}
+
+// HomographySystem approximates cs by the homographies of both cameras on the Z0 plane,
+// keeping the camera positions for plotting.
+func (cs CameraSystem) HomographySystem() (*HomographySystem, error) {
+	var h [2]*mat.Dense
+	for cami := range h {
+		var err error
+		if h[cami], err = cs.Homography(cami); err != nil {
+			return nil, fmt.Errorf("camera%d: %w", cami+1, err)
+		}
+	}
+	hs := NewHomographySystem(h[0], h[1], cs.tconfig.Z0)
+	hs.SetPositions(mat.VecDenseCopyOf(&cs.config.C1), mat.VecDenseCopyOf(&cs.config.C2))
+	return hs, nil
+}
+
+// Project maps plots with the homography of camera cami.
+// Plots on the other side of the horizon from the bottom center of the screen are invalid.
+func (hs HomographySystem) Project(cami int, plots []vannotate.ScreenPlot) (*mat.Dense, []bool, error) {
+	if err := checkCamera(cami); err != nil {
+		return nil, nil, err
+	}
+	h := hs.h[cami]
+	wref := h.At(2, 1)*-0.5 + h.At(2, 2)
+	ret := mat.NewDense(len(plots), 3, nil)
+	valid := make([]bool, len(plots))
+	for i, plot := range plots {
+		w := h.At(2, 0)*plot.P + h.At(2, 1)*plot.Q + h.At(2, 2)
+		if w*wref <= 0 {
+			ret.SetRow(i, []float64{math.NaN(), math.NaN(), math.NaN()})
+			continue
+		}
+		x := (h.At(0, 0)*plot.P + h.At(0, 1)*plot.Q + h.At(0, 2)) / w
+		y := (h.At(1, 0)*plot.P + h.At(1, 1)*plot.Q + h.At(1, 2)) / w
+		ret.SetRow(i, []float64{x, y, hs.z0})
+		valid[i] = true
+	}
+	return ret, valid, nil
+}
+
+func (hs HomographySystem) Position(cami int) (float64, float64, bool) {
+	if checkCamera(cami) != nil {
+		return 0, 0, false
+	}
+	c := hs.center[cami]
+	if c == nil {
+		return 0, 0, false
+	}
+	return c.At(0, 0), c.At(1, 0), true
+}
+
+func (hs HomographySystem) Idenitfy(ctx context.Context, srList1, srList2 []vannotate.Series, cons Constraints) ([]IPlots, error) {
+	return identify(ctx, hs, srList1, srList2, cons)
+}
+
+func (hs HomographySystem) Plot(filePath string, srLists ...[]vannotate.Series) error {
+	return plotSeries(hs, filePath, srLists...)
+}
+
+func (hs HomographySystem) PlotJoined(filePath string, iplots []IPlots) error {
+	return plotJoined(hs, filePath, iplots)
+}
+
+func (hs HomographySystem) MarshalJSON() ([]byte, error) {
+	v := &struct {
+		Model string    `json:"model"`
+		H1    []float64 `json:"h1"`
+		H2    []float64 `json:"h2"`
+		Z0    float64   `json:"z0"`
+		C1    []float64 `json:"c1,omitempty"`
+		C2    []float64 `json:"c2,omitempty"`
+	}{
+		Model: "homography",
+		H1:    hs.h[0].RawMatrix().Data,
+		H2:    hs.h[1].RawMatrix().Data,
+		Z0:    hs.z0,
+	}
+	if hs.center[0] != nil && hs.center[1] != nil {
+		v.C1 = hs.center[0].RawVector().Data
+		v.C2 = hs.center[1].RawVector().Data
+	}
+	s, err := json.Marshal(v)
+	return s, err
+}
+
+func (hs *HomographySystem) UnmarshalJSON(b []byte) error {
+	hs2 := &struct {
+		H1 []float64 `json:"h1"`
+		H2 []float64 `json:"h2"`
+		Z0 float64   `json:"z0"`
+		C1 []float64 `json:"c1"`
+		C2 []float64 `json:"c2"`
+	}{}
+	if err := json.Unmarshal(b, hs2); err != nil {
+		return err
+	}
+	if len(hs2.H1) != 9 || len(hs2.H2) != 9 {
+		return fmt.Errorf("homography: %w: h1 and h2 should have 9 elements", ErrInvalidCalibration)
+	}
+	hs.h = [2]*mat.Dense{mat.NewDense(3, 3, hs2.H1), mat.NewDense(3, 3, hs2.H2)}
+	hs.z0 = hs2.Z0
+	if len(hs2.C1) == 3 && len(hs2.C2) == 3 {
+		hs.center = [2]*mat.VecDense{mat.NewVecDense(3, hs2.C1), mat.NewVecDense(3, hs2.C2)}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package vtrack
+
+import (
+	"math"
+	"testing"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestEstimateHomography(t *testing.T) {
+	want := mat.NewDense(3, 3, []float64{
+		20, 1, 0.5,
+		0.3, 18, 2,
+		0.05, 0.4, 1,
+	})
+	hs := NewHomographySystem(want, want, 0)
+	var cors []Correspondence
+	for _, p := range []float64{-0.5, -0.2, 0.1, 0.4} {
+		for _, q := range []float64{-0.5, -0.3, -0.1} {
+			sp := vannotate.ScreenPlot{P: p, Q: q}
+			m, valid, err := hs.Project(0, []vannotate.ScreenPlot{sp})
+			if err != nil || !valid[0] {
+				t.Fatalf("projecting %v: %v, %v", sp, valid, err)
+			}
+			cors = append(cors, Correspondence{Screen: sp, X: m.At(0, 0), Y: m.At(0, 1)})
+		}
+	}
+	got, err := EstimateHomography(cors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mat.EqualApprox(got, want, 1e-6) {
+		t.Errorf("got\n%v\nwant\n%v", mat.Formatted(got), mat.Formatted(want))
+	}
+
+	if _, err := EstimateHomography(cors[:3]); err == nil {
+		t.Error("estimated a homography from 3 correspondences")
+	}
+}
+
+func TestCameraSystemHomographySystem(t *testing.T) {
+	cs := synthCameraSystem()
+	cs.params = mat.NewVecDense(5, []float64{-0.3, -0.3, -0.5 * math.Pi, 0.2, -0.2})
+	cs.tconfig.Z0 = 1.7
+	hs, err := cs.HomographySystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plots := []vannotate.ScreenPlot{{P: -0.3, Q: -0.4}, {P: 0.2, Q: -0.2}, {P: 0.4, Q: -0.45}}
+	for cami := 0; cami < 2; cami++ {
+		want, _, _ := cs.Project(cami, plots)
+		got, valid, err := hs.Project(cami, plots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range plots {
+			if !valid[i] || math.Hypot(got.At(i, 0)-want.At(i, 0), got.At(i, 1)-want.At(i, 1)) > 1e-6 {
+				t.Errorf("camera%d plot %d: got %v, want %v", cami+1, i, got.RawRowView(i), want.RawRowView(i))
+			}
+		}
+	}
+}
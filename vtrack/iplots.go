@@ -2,6 +2,7 @@ package vtrack
 
 import (
 	"encoding/json"
+	"math"
 
 	"github.com/payashi/vannotate"
 	"gonum.org/v1/gonum/mat"
@@ -13,59 +14,196 @@ type IPlots struct {
 	Size       int
 	Plots      *mat.Dense
 	Start, End int
-	i, j       int
-	sr1, sr2   vannotate.Series
+	// Number of frames dropped in each camera because their rays miss the ground
+	Invalid1, Invalid2 int
+	Velocities         *mat.Dense // set by Smooth
+	Disagreement       []float64  // distance between the two cameras' positions, NaN unless both see it
+	Series1, Series2   []int      // indices of the series of each camera making up the identity
+	// Indices given by vannotate.GetSeries of the series making up the identity,
+	// which differ from Series1 and Series2 if the series are stitched
+	Fragments1, Fragments2 []int
+	Breakdown              CostBreakdown
+	Cost                   float64      // weighted sum of Breakdown, which matching minimizes
+	Score                  float64      // relative score of the match against its alternatives and no match, in (0, 1)
+	ScoreRatio             float64      // score of the match over that of the runner-up, not a likelihood ratio
+	RunnerUp               *Alternative // most likely alternative, nil if it is no match
+	Forced                 bool         // required by Constraints
+	i, j                   int
+	sr1, sr2               vannotate.Series
+	obs                    [2]*mat.Dense // projection of each camera, NaN where unseen
+}
+
+// Projections of a series aligned with [start, start+size), NaN where it is unseen or invalid
+func newObs(m *mat.Dense, valid []bool, sr vannotate.Series, start, size int) *mat.Dense {
+	ret := mat.NewDense(size, 3, nil)
+	for i := 0; i < size; i++ {
+		t := start + i
+		if sr.Start <= t && t <= sr.End && valid[t] {
+			ret.SetRow(i, m.RawRowView(t))
+		} else {
+			ret.SetRow(i, []float64{math.NaN(), math.NaN(), math.NaN()})
+		}
+	}
+	return ret
 }
 
 func (ip *IPlots) UnmarshalJSON(b []byte) error {
 	ip2 := &struct {
-		Loss  float64     `json:"loss"`
-		Size  int         `json:"size"`
-		Start int         `json:"start"`
-		End   int         `json:"end"`
-		I     int         `json:"i"`
-		J     int         `json:"j"`
-		Plots [][]float64 `json:"plots"`
+		Loss         float64       `json:"loss"`
+		Invalid1     int           `json:"invalid1"`
+		Invalid2     int           `json:"invalid2"`
+		Size         int           `json:"size"`
+		Start        int           `json:"start"`
+		End          int           `json:"end"`
+		I            int           `json:"i"`
+		J            int           `json:"j"`
+		Plots        [][]float64   `json:"plots"`
+		Velocities   [][]float64   `json:"velocities,omitempty"`
+		Disagreement []*float64    `json:"disagreement,omitempty"`
+		Series1      []int         `json:"series1,omitempty"`
+		Series2      []int         `json:"series2,omitempty"`
+		Fragments1   []int         `json:"fragments1,omitempty"`
+		Fragments2   []int         `json:"fragments2,omitempty"`
+		Breakdown    CostBreakdown `json:"breakdown"`
+		Cost         float64       `json:"cost"`
+		Score        float64       `json:"score"`
+		Prob         float64       `json:"prob"` // Score written before it was renamed
+		Ratio        float64       `json:"scoreratio"`
+		OldRatio     float64       `json:"likelihoodratio"` // Ratio written before it was renamed
+		RunnerUp     *Alternative  `json:"runnerup"`
+		Forced       bool          `json:"forced,omitempty"`
 	}{}
 	err := json.Unmarshal(b, ip2)
 	ip.Loss = ip2.Loss
+	ip.Invalid1 = ip2.Invalid1
+	ip.Invalid2 = ip2.Invalid2
 	ip.Size = ip2.Size
 	ip.Start = ip2.Start
 	ip.End = ip2.End
 	ip.i = ip2.I
 	ip.j = ip2.J
+	ip.Series1, ip.Series2 = ip2.Series1, ip2.Series2
+	ip.Fragments1, ip.Fragments2 = ip2.Fragments1, ip2.Fragments2
+	ip.Breakdown, ip.Cost = ip2.Breakdown, ip2.Cost
+	ip.Score, ip.ScoreRatio, ip.RunnerUp = ip2.Score, ip2.Ratio, ip2.RunnerUp
+	if ip.Score == 0 {
+		ip.Score = ip2.Prob
+	}
+	if ip.ScoreRatio == 0 {
+		ip.ScoreRatio = ip2.OldRatio
+	}
+	ip.Forced = ip2.Forced
+	if ip.Series1 == nil && ip.Series2 == nil {
+		// Written before many-to-many association
+		ip.Series1, ip.Series2 = []int{ip.i}, []int{ip.j}
+	}
+	if len(ip2.Velocities) > 0 {
+		ip.Velocities = mat.NewDense(len(ip2.Velocities), 3, nil)
+		for i, v := range ip2.Velocities {
+			if v == nil {
+				ip.Velocities.SetRow(i, []float64{math.NaN(), math.NaN(), math.NaN()})
+				continue
+			}
+			ip.Velocities.SetRow(i, v)
+		}
+	}
+	if len(ip2.Disagreement) > 0 {
+		ip.Disagreement = make([]float64, len(ip2.Disagreement))
+		for i, v := range ip2.Disagreement {
+			ip.Disagreement[i] = math.NaN()
+			if v != nil {
+				ip.Disagreement[i] = *v
+			}
+		}
+	}
 	ip.Plots = mat.NewDense(len(ip2.Plots), 3, nil)
 	for i := 0; i < len(ip2.Plots); i++ {
+		// null rows are frames without a valid position
+		if ip2.Plots[i] == nil {
+			ip.Plots.SetRow(i, []float64{math.NaN(), math.NaN(), math.NaN()})
+			continue
+		}
 		ip.Plots.SetRow(i, ip2.Plots[i])
 	}
 	return err
 }
 
+// Rows of m, null where they are NaN
+func nullRows(m *mat.Dense) [][]float64 {
+	if m == nil {
+		return nil
+	}
+	r, _ := m.Dims()
+	ret := make([][]float64, r)
+	for i := 0; i < r; i++ {
+		if math.IsNaN(m.At(i, 0)) {
+			continue
+		}
+		ret[i] = make([]float64, 3)
+		for j := 0; j < 3; j++ {
+			ret[i][j] = m.At(i, j)
+		}
+	}
+	return ret
+}
+
 func (ip IPlots) MarshalJSON() ([]byte, error) {
 	plots := make([][]float64, ip.Size)
-	for i := 0; i < ip.Size; i++ {
-		plots[i] = make([]float64, 3)
-		for j := 0; j < 3; j++ {
-			plots[i][j] = ip.Plots.At(i, j)
+	copy(plots, nullRows(ip.Plots))
+	var disagreement []*float64
+	if len(ip.Disagreement) > 0 {
+		disagreement = make([]*float64, len(ip.Disagreement))
+		for i := range ip.Disagreement {
+			if !math.IsNaN(ip.Disagreement[i]) {
+				disagreement[i] = &ip.Disagreement[i]
+			}
 		}
 	}
 
 	v := &struct {
-		Loss  float64     `json:"loss"`
-		Size  int         `json:"size"`
-		Start int         `json:"start"`
-		End   int         `json:"end"`
-		I     int         `json:"i"`
-		J     int         `json:"j"`
-		Plots [][]float64 `json:"plots"`
+		Loss         float64       `json:"loss"`
+		Invalid1     int           `json:"invalid1"`
+		Invalid2     int           `json:"invalid2"`
+		Size         int           `json:"size"`
+		Start        int           `json:"start"`
+		End          int           `json:"end"`
+		I            int           `json:"i"`
+		J            int           `json:"j"`
+		Plots        [][]float64   `json:"plots"`
+		Velocities   [][]float64   `json:"velocities,omitempty"`
+		Disagreement []*float64    `json:"disagreement,omitempty"`
+		Series1      []int         `json:"series1,omitempty"`
+		Series2      []int         `json:"series2,omitempty"`
+		Fragments1   []int         `json:"fragments1,omitempty"`
+		Fragments2   []int         `json:"fragments2,omitempty"`
+		Breakdown    CostBreakdown `json:"breakdown"`
+		Cost         float64       `json:"cost"`
+		Score        float64       `json:"score"`
+		Ratio        float64       `json:"scoreratio"`
+		RunnerUp     *Alternative  `json:"runnerup"`
+		Forced       bool          `json:"forced,omitempty"`
 	}{
-		Loss:  ip.Loss,
-		Size:  ip.Size,
-		Start: ip.Start,
-		End:   ip.End,
-		I:     ip.i,
-		J:     ip.j,
-		Plots: plots,
+		Loss:         ip.Loss,
+		Invalid1:     ip.Invalid1,
+		Invalid2:     ip.Invalid2,
+		Size:         ip.Size,
+		Start:        ip.Start,
+		End:          ip.End,
+		I:            ip.i,
+		J:            ip.j,
+		Plots:        plots,
+		Velocities:   nullRows(ip.Velocities),
+		Disagreement: disagreement,
+		Series1:      ip.Series1,
+		Series2:      ip.Series2,
+		Fragments1:   ip.Fragments1,
+		Fragments2:   ip.Fragments2,
+		Breakdown:    ip.Breakdown,
+		Cost:         ip.Cost,
+		Score:        ip.Score,
+		Ratio:        ip.ScoreRatio,
+		RunnerUp:     ip.RunnerUp,
+		Forced:       ip.Forced,
 	}
 	s, err := json.Marshal(v)
 	return s, err
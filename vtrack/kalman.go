@@ -0,0 +1,217 @@
+package vtrack
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type KalmanConfig struct {
+	Dt             float64 // seconds per frame
+	Accel          float64 // spectral density of the white acceleration noise
+	Noise1, Noise2 float64 // standard deviation of each camera's measurement
+	MaxGap         int     // frames without measurement to fill at most
+}
+
+// Smooth runs a constant-velocity Kalman filter and a Rauch–Tung–Striebel smoother
+// over the trajectory, weighting each camera by its measurement noise.
+// It returns a copy whose Plots are the smoothed positions and whose Velocities are set.
+// Gaps longer than MaxGap are left as NaN.
+func (ip IPlots) Smooth(kconfig KalmanConfig) IPlots {
+	const dim = 6 // x, y, z, vx, vy, vz
+	obs, noise := ip.measurements(kconfig)
+	n := ip.Size
+
+	dt := kconfig.Dt
+	f := mat.NewDense(dim, dim, nil)
+	q := mat.NewDense(dim, dim, nil)
+	for i := 0; i < 3; i++ {
+		f.Set(i, i, 1)
+		f.Set(i, i+3, dt)
+		f.Set(i+3, i+3, 1)
+		q.Set(i, i, kconfig.Accel*dt*dt*dt/3)
+		q.Set(i, i+3, kconfig.Accel*dt*dt/2)
+		q.Set(i+3, i, kconfig.Accel*dt*dt/2)
+		q.Set(i+3, i+3, kconfig.Accel*dt)
+	}
+	h := mat.NewDense(3, dim, nil)
+	for i := 0; i < 3; i++ {
+		h.Set(i, i, 1)
+	}
+
+	// Forward filter
+	xfs := make([]*mat.VecDense, n) // filtered
+	pfs := make([]*mat.Dense, n)
+	xps := make([]*mat.VecDense, n) // predicted
+	pps := make([]*mat.Dense, n)
+	first := -1
+	for t := 0; t < n; t++ {
+		var x *mat.VecDense
+		var p *mat.Dense
+		if first == -1 {
+			// Initialize at the first measurement with a vague prior
+			z, ok := firstMeasurement(obs, noise, t)
+			if !ok {
+				continue
+			}
+			first = t
+			x = mat.NewVecDense(dim, nil)
+			p = mat.NewDense(dim, dim, nil)
+			for i := 0; i < 3; i++ {
+				x.SetVec(i, z.At(i, 0))
+				p.Set(i, i, 1e4)
+				p.Set(i+3, i+3, 1e2)
+			}
+		} else {
+			x = mat.NewVecDense(dim, nil)
+			x.MulVec(f, xfs[t-1])
+			p = mat.NewDense(dim, dim, nil)
+			p.Product(f, pfs[t-1], f.T())
+			p.Add(p, q)
+		}
+		xps[t], pps[t] = mat.VecDenseCopyOf(x), mat.DenseCopyOf(p)
+
+		// Sequential update with each camera
+		for cami := 0; cami < 2; cami++ {
+			if math.IsNaN(obs[cami].At(t, 0)) {
+				continue
+			}
+			z := mat.NewVecDense(3, obs[cami].RawRowView(t))
+			x, p = kalmanUpdate(x, p, h, z, noise[cami])
+		}
+		xfs[t], pfs[t] = x, p
+	}
+
+	ret := ip
+	ret.Plots = mat.NewDense(n, 3, nil)
+	ret.Velocities = mat.NewDense(n, 3, nil)
+	nan := []float64{math.NaN(), math.NaN(), math.NaN()}
+	if first == -1 {
+		for t := 0; t < n; t++ {
+			ret.Plots.SetRow(t, nan)
+			ret.Velocities.SetRow(t, nan)
+		}
+		return ret
+	}
+
+	// Backward smoother
+	xs := make([]*mat.VecDense, n)
+	xs[n-1] = xfs[n-1]
+	for t := n - 2; t >= first; t-- {
+		var ppinv mat.Dense
+		if err := ppinv.Inverse(pps[t+1]); err != nil {
+			xs[t] = xfs[t]
+			continue
+		}
+		c := mat.NewDense(dim, dim, nil)
+		c.Product(pfs[t], f.T(), &ppinv)
+		d := mat.NewVecDense(dim, nil)
+		d.SubVec(xs[t+1], xps[t+1])
+		x := mat.NewVecDense(dim, nil)
+		x.MulVec(c, d)
+		x.AddVec(xfs[t], x)
+		xs[t] = x
+	}
+
+	gaps := gapLengths(obs, n)
+	for t := 0; t < n; t++ {
+		if t < first || gaps[t] > kconfig.MaxGap {
+			ret.Plots.SetRow(t, nan)
+			ret.Velocities.SetRow(t, nan)
+			continue
+		}
+		ret.Plots.SetRow(t, xs[t].RawVector().Data[:3])
+		ret.Velocities.SetRow(t, xs[t].RawVector().Data[3:])
+	}
+	return ret
+}
+
+// Measurements of each camera aligned with Plots and their variances.
+// Trajectories loaded from json have no per-camera measurements, so Plots is used instead.
+func (ip IPlots) measurements(kconfig KalmanConfig) ([2]*mat.Dense, [2]float64) {
+	noise := [2]float64{kconfig.Noise1 * kconfig.Noise1, kconfig.Noise2 * kconfig.Noise2}
+	if ip.obs[0] != nil && ip.obs[1] != nil {
+		return ip.obs, noise
+	}
+	unseen := mat.NewDense(ip.Size, 3, nil)
+	for t := 0; t < ip.Size; t++ {
+		unseen.SetRow(t, []float64{math.NaN(), math.NaN(), math.NaN()})
+	}
+	return [2]*mat.Dense{ip.Plots, unseen}, [2]float64{math.Min(noise[0], noise[1]), 0}
+}
+
+// First available measurement at t, preferring the less noisy camera
+func firstMeasurement(obs [2]*mat.Dense, noise [2]float64, t int) (*mat.VecDense, bool) {
+	best := -1
+	for cami := 0; cami < 2; cami++ {
+		if math.IsNaN(obs[cami].At(t, 0)) {
+			continue
+		}
+		if best == -1 || noise[cami] < noise[best] {
+			best = cami
+		}
+	}
+	if best == -1 {
+		return nil, false
+	}
+	return mat.NewVecDense(3, obs[best].RawRowView(t)), true
+}
+
+func kalmanUpdate(x *mat.VecDense, p, h *mat.Dense, z *mat.VecDense, r float64) (*mat.VecDense, *mat.Dense) {
+	dim := x.Len()
+	// Innovation and its covariance
+	y := mat.NewVecDense(3, nil)
+	y.MulVec(h, x)
+	y.SubVec(z, y)
+	s := mat.NewDense(3, 3, nil)
+	s.Product(h, p, h.T())
+	for i := 0; i < 3; i++ {
+		s.Set(i, i, s.At(i, i)+r)
+	}
+	var sinv mat.Dense
+	if err := sinv.Inverse(s); err != nil {
+		return x, p
+	}
+
+	k := mat.NewDense(dim, 3, nil)
+	k.Product(p, h.T(), &sinv)
+	nx := mat.NewVecDense(dim, nil)
+	nx.MulVec(k, y)
+	nx.AddVec(x, nx)
+
+	kh := mat.NewDense(dim, dim, nil)
+	kh.Mul(k, h)
+	ikh := mat.NewDense(dim, dim, nil)
+	for i := 0; i < dim; i++ {
+		ikh.Set(i, i, 1)
+	}
+	ikh.Sub(ikh, kh)
+	np := mat.NewDense(dim, dim, nil)
+	np.Mul(ikh, p)
+	return nx, np
+}
+
+// Length of the run of frames without measurement each frame belongs to, 0 if measured
+func gapLengths(obs [2]*mat.Dense, n int) []int {
+	ret := make([]int, n)
+	for t := 0; t < n; {
+		if !math.IsNaN(obs[0].At(t, 0)) || !math.IsNaN(obs[1].At(t, 0)) {
+			t++
+			continue
+		}
+		end := t
+		for end < n && math.IsNaN(obs[0].At(end, 0)) && math.IsNaN(obs[1].At(end, 0)) {
+			end++
+		}
+		// A gap at the end of the trajectory is never filled
+		length := end - t
+		if end == n {
+			length = math.MaxInt
+		}
+		for i := t; i < end; i++ {
+			ret[i] = length
+		}
+		t = end
+	}
+	return ret
+}
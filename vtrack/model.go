@@ -0,0 +1,78 @@
+package vtrack
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Camera model which maps screen plots of two cameras onto the world
+type CameraModel interface {
+	// Project maps screen plots of camera cami to world coordinates, one row per plot.
+	// Plots whose rays do not hit the ground are reported as invalid,
+	// and a camera other than 0 or 1 as ErrInvalidCamera.
+	Project(cami int, plots []vannotate.ScreenPlot) (*mat.Dense, []bool, error)
+	// Position returns the ground position of camera cami, if it is known
+	Position(cami int) (x, y float64, ok bool)
+	// Sigma returns the standard deviation of a projected position p of camera cami
+	// detected with confidence conf, and ErrInvalidCamera for a camera other than 0 or 1
+	Sigma(cami int, p []float64, conf float32) (float64, error)
+}
+
+func (cs CameraSystem) Project(cami int, plots []vannotate.ScreenPlot) (*mat.Dense, []bool, error) {
+	if err := checkCamera(cami); err != nil {
+		return nil, nil, err
+	}
+	pm, valid := cs.project(cs.params, cami, plots)
+	return pm, valid, nil
+}
+
+func (cs CameraSystem) Position(cami int) (float64, float64, bool) {
+	if checkCamera(cami) != nil {
+		return 0, 0, false
+	}
+	_, _, c := cs.getConfig(cami)
+	return c.At(0, 0), c.At(1, 0), true
+}
+
+// Count of invalid plots
+func countInvalid(valid []bool) int {
+	ret := 0
+	for _, v := range valid {
+		if !v {
+			ret++
+		}
+	}
+	return ret
+}
+
+// LoadCameraModel loads either a pinhole or a homography model from a json file.
+// A file which is not a camera model is reported as ErrInvalidCalibration.
+func LoadCameraModel(filePath string) (CameraModel, error) {
+	b, err := readFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &struct {
+		Model string `json:"model"`
+	}{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", filePath, ErrInvalidCalibration, err)
+	}
+	var m CameraModel
+	switch v.Model {
+	case "", "pinhole":
+		m = &CameraSystem{}
+	case "homography":
+		m = &HomographySystem{}
+	default:
+		return nil, fmt.Errorf("%s: %w: unknown model %q", filePath, ErrInvalidCalibration, v.Model)
+	}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("%s: %w: %v", filePath, ErrInvalidCalibration, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,62 @@
+package vtrack
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/payashi/vannotate"
+)
+
+func TestProjectRejectsInvalidCamera(t *testing.T) {
+	plots := []vannotate.ScreenPlot{{P: 0, Q: -0.3}}
+	for _, m := range []CameraModel{synthCameraSystem(), synthHomographySystem()} {
+		for _, cami := range []int{-1, 2} {
+			if _, _, err := m.Project(cami, plots); !errors.Is(err, ErrInvalidCamera) {
+				t.Errorf("%T camera %d: got %v, want %v", m, cami, err, ErrInvalidCamera)
+			}
+			if _, _, ok := m.Position(cami); ok {
+				t.Errorf("%T camera %d has a position", m, cami)
+			}
+		}
+		if _, valid, err := m.Project(1, plots); err != nil || len(valid) != 1 {
+			t.Errorf("%T camera 1: got %v, %v", m, valid, err)
+		}
+	}
+}
+
+func TestSigmaRejectsInvalidCamera(t *testing.T) {
+	p := []float64{0, -8, 1.7}
+	for _, m := range []CameraModel{synthCameraSystem(), synthHomographySystem()} {
+		for _, cami := range []int{-1, 2} {
+			if _, err := m.Sigma(cami, p, 0.9); !errors.Is(err, ErrInvalidCamera) {
+				t.Errorf("%T camera %d: got %v, want %v", m, cami, err, ErrInvalidCamera)
+			}
+		}
+		if s, err := m.Sigma(1, p, 0.9); err != nil || !(s > 0) {
+			t.Errorf("%T camera 1: got %v, %v", m, s, err)
+		}
+	}
+}
+
+func TestLoadCameraModelRejectsMalformed(t *testing.T) {
+	for name, body := range map[string]string{
+		"short c1":  `{"model": "pinhole", "c1": [0, 0], "c2": [0, -18.97, 3.904]}`,
+		"no c2":     `{"model": "pinhole", "c1": [0, 0, 4.028]}`,
+		"short h2":  `{"model": "homography", "h1": [1, 0, 0, 0, 1, 0, 0, 0, 1], "h2": [1, 0, 0]}`,
+		"not json":  `{"model": "pinhole", "c1": `,
+		"bad model": `{"model": "fisheye"}`,
+	} {
+		filePath := filepath.Join(t.TempDir(), "camsys.json")
+		if err := os.WriteFile(filePath, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCameraModel(filePath); !errors.Is(err, ErrInvalidCalibration) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidCalibration)
+		}
+		if _, err := LoadCameraSystem(filePath); err == nil {
+			t.Errorf("%s: loaded as a camera system", name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package vtrack
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+func plotFrame(m CameraModel, p *plot.Plot) error {
+	frame := []vannotate.ScreenPlot{
+		{P: -0.5, Q: -0.5}, // bottom left
+		{P: +0.5, Q: -0.5}, // bottom right
+		{P: +0.5, Q: 0.},   // mid right
+		{P: -0.5, Q: 0.},   // mid left
+	}
+
+	// Plot lower part of frame of each camera
+	colors := []color.Color{color.RGBA{0, 255, 255, 128}, color.RGBA{255, 0, 255, 128}}
+	for cami := 0; cami <= 1; cami++ {
+		fm, ok, err := m.Project(cami, frame)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 4; i++ {
+			ni := (i + 1) % 4
+			if !ok[i] || !ok[ni] {
+				continue
+			}
+			ploti, err := plotter.NewLine(plotter.XYs{
+				{X: fm.At(i, 0), Y: fm.At(i, 1)},
+				{X: fm.At(ni, 0), Y: fm.At(ni, 1)},
+			})
+			if err != nil {
+				return err
+			}
+			if i == 2 {
+				ploti.LineStyle = draw.LineStyle{
+					Color: color.RGBA{255, 255, 255, 0},
+					Width: 3.,
+				}
+			} else {
+				ploti.Color = colors[cami]
+			}
+			p.Add(ploti)
+		}
+	}
+
+	// Plot camera positions if the model knows them
+	centers := plotter.XYs{}
+	for cami := 0; cami <= 1; cami++ {
+		if x, y, ok := m.Position(cami); ok {
+			centers = append(centers, plotter.XY{X: x, Y: y})
+		}
+	}
+	if len(centers) == 0 {
+		return nil
+	}
+	scatter, err := plotter.NewScatter(centers)
+	if err != nil {
+		return err
+	}
+	p.Add(scatter)
+	return nil
+}
+
+func plotJoined(m CameraModel, filePath string, iplots []IPlots) error {
+	p := plot.New()
+	if err := plotFrame(m, p); err != nil {
+		return err
+	}
+	for i, iplot := range iplots {
+		fmt.Printf("iplots[%d]: %v-%v\n", i, iplot.Series1, iplot.Series2)
+		for j := 0; j < iplot.Size-1; j++ {
+			if math.IsNaN(iplot.Plots.At(j, 0)) || math.IsNaN(iplot.Plots.At(j+1, 0)) {
+				continue
+			}
+			ploti, err := plotter.NewLine(plotter.XYs{
+				{X: iplot.Plots.At(j, 0), Y: iplot.Plots.At(j, 1)},
+				{X: iplot.Plots.At(j+1, 0), Y: iplot.Plots.At(j+1, 1)},
+			})
+			if err != nil {
+				return err
+			}
+			ploti.Color = plotutil.Color(i)
+			p.Add(ploti)
+		}
+	}
+	p.Add(plotter.NewGrid())
+	p.X.Max = 15
+	p.X.Min = 0
+	p.Y.Max = 10
+	p.Y.Min = -30
+
+	return p.Save(vg.Inch*30, vg.Inch*30, filePath)
+}
+
+func plotSeries(m CameraModel, filePath string, srLists ...[]vannotate.Series) error {
+	p := plot.New()
+	if err := plotFrame(m, p); err != nil {
+		return err
+	}
+
+	for cami := 0; cami < minInt(2, len(srLists)); cami++ {
+		for _, sr := range srLists[cami] {
+			plots := sr.Plots[sr.Start : sr.End+1]
+			nplots := len(plots)
+			pm, ok, err := m.Project(cami, plots)
+			if err != nil {
+				return err
+			}
+
+			for j := 0; j < nplots-1; j++ {
+				if !ok[j] || !ok[j+1] {
+					continue
+				}
+				ploti, err := plotter.NewLine(plotter.XYs{
+					{X: pm.At(j, 0), Y: pm.At(j, 1)},
+					{X: pm.At(j+1, 0), Y: pm.At(j+1, 1)},
+				})
+				if err != nil {
+					return err
+				}
+				ploti.Color = plotutil.Color(cami)
+				p.Add(ploti)
+			}
+		}
+	}
+
+	p.Add(plotter.NewGrid())
+	p.X.Max = -5
+	p.X.Min = +20
+	p.Y.Max = +5
+	p.Y.Min = -20
+
+	return p.Save(vg.Inch*30, vg.Inch*30, filePath)
+}
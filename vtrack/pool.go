@@ -0,0 +1,67 @@
+package vtrack
+
+import (
+	"runtime"
+	"sync"
+)
+
+// SetParallelism sets the number of workers computing pairwise costs and gradients,
+// or one per CPU if n is not positive. 1 runs them sequentially.
+func (cs *CameraSystem) SetParallelism(n int) {
+	cs.workers = n
+}
+
+// SetParallelism sets the number of workers computing pairwise costs,
+// or one per CPU if n is not positive. 1 runs them sequentially.
+func (hs *HomographySystem) SetParallelism(n int) {
+	hs.workers = n
+}
+
+func (cs CameraSystem) parallelism() int     { return cs.workers }
+func (hs HomographySystem) parallelism() int { return hs.workers }
+
+// Camera model with a number of workers set by SetParallelism
+type parallelModel interface {
+	parallelism() int
+}
+
+// parallelismOf returns the number of workers set on m, 0 if it has none.
+func parallelismOf(m CameraModel) int {
+	if pm, ok := m.(parallelModel); ok {
+		return pm.parallelism()
+	}
+	return 0
+}
+
+// parallelFor calls f for every k in [0, n) on a bounded pool of workers,
+// one per CPU if workers is not positive.
+// f must only write to results indexed by k, so that they do not depend on the scheduling.
+func parallelFor(workers, n int, f func(k int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = minInt(workers, n)
+	if workers <= 1 {
+		for k := 0; k < n; k++ {
+			f(k)
+		}
+		return
+	}
+
+	ks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range ks {
+				f(k)
+			}
+		}()
+	}
+	for k := 0; k < n; k++ {
+		ks <- k
+	}
+	close(ks)
+	wg.Wait()
+}
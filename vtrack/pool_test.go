@@ -0,0 +1,57 @@
+package vtrack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestIdentifyParallelMatchesSerial(t *testing.T) {
+	srLists := synthSeries(8, 300, 1)
+	var results [][]byte
+	for _, workers := range []int{1, 4} {
+		hs := synthHomographySystem()
+		hs.SetParallelism(workers)
+		ipList, err := hs.Idenitfy(context.Background(), srLists[0], srLists[1], Constraints{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ipList) == 0 {
+			t.Fatalf("no matches with %d workers", workers)
+		}
+		b, err := json.Marshal(ipList)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, b)
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Errorf("parallel matches differ from serial ones")
+	}
+}
+
+func TestTuneParallelMatchesSerial(t *testing.T) {
+	srLists := synthSeries(1, 200, 2)
+	var results [][]byte
+	for _, workers := range []int{1, 4} {
+		cs := synthCameraSystem()
+		cs.SetParallelism(workers)
+		sp, err := NewSyncedPlots(srLists[0][0], srLists[1][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tconfig := TuneConfig{Ntrials: 50, Dp: 1e-2, Mu: 1e-2, Z0: 1.7, Plots: sp}
+		if err := cs.Tune(context.Background(), tconfig); err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, b)
+	}
+	if !bytes.Equal(results[0], results[1]) {
+		t.Errorf("parallel tuning differs from serial one:\n%s\n%s", results[0], results[1])
+	}
+}
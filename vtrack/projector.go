@@ -0,0 +1,83 @@
+package vtrack
+
+import (
+	"math"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// projector projects screen plots of one camera onto the surface z0 above the ground
+// with a basis computed once for a set of params.
+type projector struct {
+	n, a, b, c [3]float64
+	r, k, z0   float64
+	ground     Ground
+	gr         groundRange
+}
+
+// newProjector returns the projector of camera cami, which must be 0 or 1, under params.
+func (cs CameraSystem) newProjector(params *mat.VecDense, cami int, z0 float64) projector {
+	if cami < 0 || 1 < cami {
+		panic("cami should be 0 or 1")
+	}
+	ret := projector{z0: z0, ground: cs.ground}
+	ret.n, ret.a, ret.b = basis(params.At(0+cami, 0), params.At(3+cami, 0))
+	var c mat.VecDense
+	ret.r, ret.k, c = cs.getConfig(cami)
+	for i := 0; i < 3; i++ {
+		ret.c[i] = c.At(i, 0)
+	}
+	if cs.ground != nil {
+		ret.gr = getGroundRange(cs.ground)
+	}
+	return ret
+}
+
+// projectInto writes the position of plots[i] into dst[3*i:3*i+3] and whether
+// its ray hits the surface in front of the camera into valid[i], without allocating.
+// Positions of invalid plots are NaN. dst and valid must be long enough for plots.
+func (p *projector) projectInto(dst []float64, valid []bool, plots []vannotate.ScreenPlot) {
+	if len(dst) < 3*len(plots) || len(valid) < len(plots) {
+		panic("buffers are too short")
+	}
+	for i, plot := range plots {
+		var d [3]float64
+		for j := 0; j < 3; j++ {
+			d[j] = plot.P*p.a[j] + plot.Q/p.r*p.b[j]
+			d[j] = p.n[j] + p.k*d[j]
+		}
+		var t float64
+		ok := true
+		if p.ground == nil {
+			t = (p.z0 - p.c[2]) / d[2]
+		} else {
+			t, ok = intersectGround(p.ground, p.gr, p.c, d, p.z0)
+		}
+		pos := dst[3*i : 3*i+3]
+		if !ok || t <= 0 || math.IsInf(t, 0) || math.IsNaN(t) {
+			pos[0], pos[1], pos[2] = math.NaN(), math.NaN(), math.NaN()
+			valid[i] = false
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			pos[j] = p.c[j] + t*d[j]
+		}
+		valid[i] = true
+	}
+}
+
+// Buffers for projecting the calibration plots of both cameras
+type projBuffer struct {
+	pos   [2][]float64
+	valid [2][]bool
+}
+
+func newProjBuffer(size int) *projBuffer {
+	ret := &projBuffer{}
+	for cami := 0; cami < 2; cami++ {
+		ret.pos[cami] = make([]float64, 3*size)
+		ret.valid[cami] = make([]bool, size)
+	}
+	return ret
+}
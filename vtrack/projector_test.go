@@ -0,0 +1,202 @@
+package vtrack
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// projectPerPoint projects plots as CameraSystem.project did before the projector,
+// allocating a basis and a ray for every plot. It is kept to check and benchmark the projector.
+func (cs *CameraSystem) projectPerPoint(params *mat.VecDense, cami int, plots []vannotate.ScreenPlot, z0 float64) (*mat.Dense, []bool) {
+	theta, phi := params.At(0+cami, 0), params.At(3+cami, 0)
+	n, a, b := getBasis(theta, phi)
+
+	ret := mat.NewDense(len(plots), 3, nil)
+	valid := make([]bool, len(plots))
+	r, k, c := cs.getConfig(cami)
+	var gr groundRange
+	if cs.ground != nil {
+		gr = getGroundRange(cs.ground)
+	}
+	for i, plot := range plots {
+		d := mat.NewVecDense(3, nil)
+		d.AddScaledVec(d, plot.P, a)
+		d.AddScaledVec(d, plot.Q/r, b)
+		d.AddScaledVec(n, k, d)
+		var t float64
+		ok := true
+		if cs.ground == nil {
+			t = (z0 - c.At(2, 0)) / d.At(2, 0)
+		} else {
+			t, ok = intersectGround(cs.ground, gr,
+				[3]float64{c.At(0, 0), c.At(1, 0), c.At(2, 0)}, [3]float64{d.At(0, 0), d.At(1, 0), d.At(2, 0)}, z0)
+		}
+		if !ok || t <= 0 || math.IsInf(t, 0) || math.IsNaN(t) {
+			ret.SetRow(i, []float64{math.NaN(), math.NaN(), math.NaN()})
+			continue
+		}
+
+		d.AddScaledVec(&c, t, d)
+		ret.SetRow(i, d.RawVector().Data)
+		valid[i] = true
+	}
+	return ret, valid
+}
+
+// loadOut loads the camera system and the series of every session under out/,
+// skipping b if there are none.
+func loadOut(b *testing.B) (*CameraSystem, [2][]vannotate.Series) {
+	const outDir = "../out"
+	var srLists [2][]vannotate.Series
+	cs, err := LoadCameraSystem(filepath.Join(outDir, "camsys.json"))
+	if err != nil {
+		b.Skip(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(outDir, "*-1t.json"))
+	for _, path := range paths {
+		for cami, suffix := range []string{"1t.json", "2t.json"} {
+			f, err := os.Open(strings.TrimSuffix(path, "1t.json") + suffix)
+			if err != nil {
+				b.Skip(err)
+			}
+			var srList []vannotate.Series
+			err = json.NewDecoder(f).Decode(&srList)
+			f.Close()
+			if err != nil {
+				b.Fatal(err)
+			}
+			srLists[cami] = append(srLists[cami], srList...)
+		}
+	}
+	if len(srLists[0]) == 0 {
+		b.Skip("no series in " + outDir)
+	}
+	return cs, srLists
+}
+
+func TestProjectorMatchesPerPoint(t *testing.T) {
+	cs := synthCameraSystem()
+	cs.params = mat.NewVecDense(5, []float64{-0.4, -0.31, 0, -0.5 * math.Pi, 0.5 * math.Pi})
+	srLists := synthSeries(3, 100, 5)
+	for cami, srList := range srLists {
+		for _, sr := range srList {
+			want, wantValid := cs.projectPerPoint(cs.params, cami, sr.Plots, 1.7)
+			got, gotValid := cs.project(cs.params, cami, sr.Plots, 1.7)
+			for i := range sr.Plots {
+				if gotValid[i] != wantValid[i] {
+					t.Fatalf("camera%d plot %d: valid %v, want %v", cami+1, i, gotValid[i], wantValid[i])
+				}
+				if wantValid[i] && !mat.Equal(got.RowView(i), want.RowView(i)) {
+					t.Fatalf("camera%d plot %d: got %v, want %v", cami+1, i, got.RawRowView(i), want.RawRowView(i))
+				}
+			}
+		}
+	}
+}
+
+// Projecting the series under out/ as before the projector, with CameraSystem.Project,
+// and with a projector reusing its buffers
+func BenchmarkProjectOut(b *testing.B) {
+	cs, srLists := loadOut(b)
+	maxLen := 0
+	for _, srList := range srLists {
+		for _, sr := range srList {
+			maxLen = maxInt(maxLen, len(sr.Plots))
+		}
+	}
+	b.Run("PerPoint", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for cami, srList := range srLists {
+				for _, sr := range srList {
+					cs.projectPerPoint(cs.params, cami, sr.Plots, cs.tconfig.Z0)
+				}
+			}
+		}
+	})
+	b.Run("Project", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for cami, srList := range srLists {
+				for _, sr := range srList {
+					cs.Project(cami, sr.Plots)
+				}
+			}
+		}
+	})
+	b.Run("ProjectInto", func(b *testing.B) {
+		pos := make([]float64, 3*maxLen)
+		valid := make([]bool, maxLen)
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			for cami, srList := range srLists {
+				p := cs.newProjector(cs.params, cami, cs.tconfig.Z0)
+				for _, sr := range srList {
+					p.projectInto(pos, valid, sr.Plots)
+				}
+			}
+		}
+	})
+}
+
+// Projecting the series of a synthetic scene as before the projector, with CameraSystem.Project,
+// which allocates its results, and with a projector reusing its buffers
+
+func BenchmarkProjectPerPoint(b *testing.B) {
+	cs := synthCameraSystem()
+	srLists := synthSeries(20, 3000, 1)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for cami, srList := range srLists {
+			for _, sr := range srList {
+				cs.projectPerPoint(cs.params, cami, sr.Plots, cs.tconfig.Z0)
+			}
+		}
+	}
+}
+
+func BenchmarkProject(b *testing.B) {
+	cs := synthCameraSystem()
+	srLists := synthSeries(20, 3000, 1)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for cami, srList := range srLists {
+			for _, sr := range srList {
+				cs.Project(cami, sr.Plots)
+			}
+		}
+	}
+}
+
+func BenchmarkProjectInto(b *testing.B) {
+	cs := synthCameraSystem()
+	srLists := synthSeries(20, 3000, 1)
+	pos := make([]float64, 3*3000)
+	valid := make([]bool, 3000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for cami, srList := range srLists {
+			p := cs.newProjector(cs.params, cami, cs.tconfig.Z0)
+			for _, sr := range srList {
+				p.projectInto(pos, valid, sr.Plots)
+			}
+		}
+	}
+}
+
+func BenchmarkIdentify(b *testing.B) {
+	hs := synthHomographySystem()
+	srLists := synthSeries(20, 3000, 1)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := hs.Idenitfy(context.Background(), srLists[0], srLists[1], Constraints{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
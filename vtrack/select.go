@@ -0,0 +1,140 @@
+package vtrack
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Score of a candidate pair for calibration
+type PairScore struct {
+	I, J     int
+	Overlap  int     // number of synchronized frames
+	Span     float64 // screen distance travelled during overlap
+	Coverage float64 // screen area covered during overlap
+	Spread   float64 // deviation of projected offsets under initial params
+	Score    float64
+}
+
+// RankPairs scores every (i, j) pair of series and returns them in descending order of Score.
+// z0 is the height used to project both series under the current params.
+func (cs CameraSystem) RankPairs(srList1, srList2 []vannotate.Series, z0 float64) []PairScore {
+	const minOverlap = 10
+	ret := make([]PairScore, 0)
+	for i, sr1 := range srList1 {
+		for j, sr2 := range srList2 {
+			sp, err := NewSyncedPlots(sr1, sr2)
+			if err != nil || sp.size < minOverlap {
+				continue
+			}
+			ps := PairScore{I: i, J: j, Overlap: sp.size}
+			ps.Span = math.Min(screenSpan(sp.pl1), screenSpan(sp.pl2))
+			ps.Coverage = math.Min(screenCoverage(sp.pl1), screenCoverage(sp.pl2))
+			ps.Spread = cs.offsetSpread(sp, z0)
+			ret = append(ret, ps)
+		}
+	}
+	if len(ret) == 0 {
+		return ret
+	}
+
+	// Normalize each term by its maximum among candidates
+	var maxOverlap, maxSpan, maxCov, maxSpread float64
+	for _, ps := range ret {
+		maxOverlap = math.Max(maxOverlap, float64(ps.Overlap))
+		maxSpan = math.Max(maxSpan, ps.Span)
+		maxCov = math.Max(maxCov, ps.Coverage)
+		if !math.IsInf(ps.Spread, 0) && !math.IsNaN(ps.Spread) {
+			maxSpread = math.Max(maxSpread, ps.Spread)
+		}
+	}
+	for k := range ret {
+		ps := &ret[k]
+		consistency := .0
+		if maxSpread > 0 && ps.Spread <= maxSpread {
+			consistency = 1 - ps.Spread/maxSpread
+		}
+		ps.Score = (safeRatio(float64(ps.Overlap), maxOverlap) +
+			safeRatio(ps.Span, maxSpan) +
+			safeRatio(ps.Coverage, maxCov) +
+			consistency) / 4
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Score > ret[j].Score })
+	return ret
+}
+
+// BestSyncedPlots returns synchronized plots of the highest-ranked pair.
+func (cs CameraSystem) BestSyncedPlots(srList1, srList2 []vannotate.Series, z0 float64) (*splots, PairScore, error) {
+	ranks := cs.RankPairs(srList1, srList2, z0)
+	if len(ranks) == 0 {
+		return nil, PairScore{}, fmt.Errorf("syncedplots: no candidate pair: %w", ErrNoOverlap)
+	}
+	best := ranks[0]
+	sp, err := NewSyncedPlots(srList1[best.I], srList2[best.J])
+	return sp, best, err
+}
+
+// Mean deviation of the offset between two projected trajectories.
+// A pair of the same person keeps a nearly constant offset even under rough params.
+func (cs CameraSystem) offsetSpread(sp *splots, z0 float64) float64 {
+	m1, ok1 := cs.project(cs.params, 0, sp.pl1, z0)
+	m2, ok2 := cs.project(cs.params, 1, sp.pl2, z0)
+
+	diffs := mat.NewDense(sp.size, 3, nil)
+	diffs.Sub(m1, m2)
+	mean := mat.NewVecDense(3, nil)
+	nvalid := 0
+	for i := 0; i < sp.size; i++ {
+		if ok1[i] && ok2[i] {
+			mean.AddVec(mean, diffs.RowView(i))
+			nvalid++
+		}
+	}
+	if nvalid == 0 {
+		return math.Inf(1)
+	}
+	mean.ScaleVec(1/float64(nvalid), mean)
+
+	ret := .0
+	for i := 0; i < sp.size; i++ {
+		if !ok1[i] || !ok2[i] {
+			continue
+		}
+		d := mat.NewVecDense(3, nil)
+		d.SubVec(diffs.RowView(i), mean)
+		ret += d.Norm(2)
+	}
+	return ret / float64(nvalid)
+}
+
+func screenSpan(plots []vannotate.ScreenPlot) float64 {
+	ret := .0
+	for i := 0; i < len(plots)-1; i++ {
+		dp, dq := plots[i+1].P-plots[i].P, plots[i+1].Q-plots[i].Q
+		ret += math.Sqrt(dp*dp + dq*dq)
+	}
+	return ret
+}
+
+func screenCoverage(plots []vannotate.ScreenPlot) float64 {
+	if len(plots) == 0 {
+		return 0
+	}
+	minp, maxp := plots[0].P, plots[0].P
+	minq, maxq := plots[0].Q, plots[0].Q
+	for _, v := range plots {
+		minp, maxp = math.Min(minp, v.P), math.Max(maxp, v.P)
+		minq, maxq = math.Min(minq, v.Q), math.Max(maxq, v.Q)
+	}
+	return (maxp - minp) * (maxq - minq)
+}
+
+func safeRatio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
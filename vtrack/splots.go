@@ -1,7 +1,7 @@
 package vtrack
 
 import (
-	"errors"
+	"fmt"
 	"math"
 
 	"github.com/payashi/vannotate"
@@ -19,7 +19,7 @@ func NewSyncedPlots(sr1, sr2 vannotate.Series) (*splots, error) {
 	end := minInt(sr1.End, sr2.End)
 	// Return error when there's no overwrap
 	if start > end {
-		return nil, errors.New("syncedplots: no overwrap")
+		return nil, fmt.Errorf("syncedplots: %w", ErrNoOverlap)
 	}
 	return &splots{
 		size:  end - start + 1,
@@ -30,6 +30,11 @@ func NewSyncedPlots(sr1, sr2 vannotate.Series) (*splots, error) {
 	}, nil
 }
 
+// Len returns the number of frames of the plots.
+func (sp *splots) Len() int {
+	return sp.size
+}
+
 func maxInt(nums ...int) int {
 	ret := math.MinInt
 	for _, v := range nums {
@@ -0,0 +1,245 @@
+package vtrack
+
+import (
+	"math"
+	"sort"
+
+	"github.com/payashi/vannotate"
+	"gonum.org/v1/gonum/mat"
+)
+
+type StitchConfig struct {
+	Dt               float64 // seconds per frame
+	MaxGap           int     // frames between two fragments at most
+	Window           int     // frames used to estimate the velocity at the ends of a fragment
+	MaxSpeed         float64 // m/s; how fast the prediction error may grow during a gap
+	Slack            float64 // m; tolerance of the predicted position with no gap
+	AppearanceWeight float64
+}
+
+// End of a fragment in the world
+type fragmentEnd struct {
+	t        int // frame
+	pos, vel []float64
+	ok       bool
+}
+
+func (cs CameraSystem) Stitch(cami int, srList []vannotate.Series, sconfig StitchConfig) ([]vannotate.Series, error) {
+	return stitch(cs, cami, srList, sconfig)
+}
+
+func (hs HomographySystem) Stitch(cami int, srList []vannotate.Series, sconfig StitchConfig) ([]vannotate.Series, error) {
+	return stitch(hs, cami, srList, sconfig)
+}
+
+// stitch links fragments of the same person within camera cami into longer series.
+// A fragment is linked to a later one when its position, extrapolated with its velocity
+// over the gap, lands near where the later one starts and their appearances agree.
+// Fragments of each result are the indices into srList it is made of,
+// or the Fragments of inputs which were already stitched.
+func stitch(m CameraModel, cami int, srList []vannotate.Series, sconfig StitchConfig) ([]vannotate.Series, error) {
+	n := len(srList)
+	heads := make([]fragmentEnd, n)
+	tails := make([]fragmentEnd, n)
+	for i, sr := range srList {
+		pm, valid, err := m.Project(cami, sr.Plots)
+		if err != nil {
+			return nil, err
+		}
+		heads[i] = getFragmentEnd(pm, valid, sr, sconfig.Window, sconfig.Dt, true)
+		tails[i] = getFragmentEnd(pm, valid, sr, sconfig.Window, sconfig.Dt, false)
+	}
+
+	type link struct {
+		i, j int
+		cost float64
+	}
+	links := make([]link, 0)
+	for i, sr1 := range srList {
+		for j, sr2 := range srList {
+			gap := sr2.Start - sr1.End
+			if i == j || gap <= 0 || gap > sconfig.MaxGap {
+				continue
+			}
+			tail, head := tails[i], heads[j]
+			if !tail.ok || !head.ok || head.t <= tail.t {
+				continue
+			}
+			dt := float64(head.t-tail.t) * sconfig.Dt
+			dx := tail.pos[0] + tail.vel[0]*dt - head.pos[0]
+			dy := tail.pos[1] + tail.vel[1]*dt - head.pos[1]
+			dist := math.Hypot(dx, dy)
+			tol := sconfig.Slack + sconfig.MaxSpeed*dt
+			if dist > tol {
+				continue
+			}
+			cost := dist/tol + float64(gap)/float64(sconfig.MaxGap) +
+				sconfig.AppearanceWeight*attributeDistance(sr1.Attributes, sr2.Attributes)
+			links = append(links, link{i, j, cost})
+		}
+	}
+	sort.SliceStable(links, func(a, b int) bool { return links[a].cost < links[b].cost })
+
+	// Greedily accept the cheapest links so that each fragment has one predecessor and one successor
+	succ := make([]int, n)
+	pred := make([]int, n)
+	for i := 0; i < n; i++ {
+		succ[i], pred[i] = -1, -1
+	}
+	for _, l := range links {
+		if succ[l.i] != -1 || pred[l.j] != -1 {
+			continue
+		}
+		succ[l.i], pred[l.j] = l.j, l.i
+	}
+
+	ret := make([]vannotate.Series, 0)
+	for i := 0; i < n; i++ {
+		if pred[i] != -1 {
+			continue
+		}
+		chain := []int{i}
+		for k := succ[i]; k != -1; k = succ[k] {
+			chain = append(chain, k)
+		}
+		ret = append(ret, mergeFragments(srList, chain))
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Conf > ret[j].Conf })
+	return ret, nil
+}
+
+// getFragmentEnd returns the first (head) or the last valid position of a fragment
+// and its velocity over the window next to it.
+func getFragmentEnd(pm *mat.Dense, valid []bool, sr vannotate.Series, window int, dt float64, head bool) fragmentEnd {
+	first, last := -1, -1
+	for t := sr.Start; t <= sr.End; t++ {
+		if valid[t] {
+			if first == -1 {
+				first = t
+			}
+			last = t
+		}
+	}
+	if first == -1 {
+		return fragmentEnd{}
+	}
+
+	// Farthest valid frame within the window
+	var t0, t1 int
+	if head {
+		t0, t1 = first, first
+		for t := first; t <= minInt(first+window, last); t++ {
+			if valid[t] {
+				t1 = t
+			}
+		}
+	} else {
+		t0, t1 = last, last
+		for t := last; t >= maxInt(last-window, first); t-- {
+			if valid[t] {
+				t0 = t
+			}
+		}
+	}
+
+	ret := fragmentEnd{ok: true, vel: make([]float64, 3)}
+	if head {
+		ret.t, ret.pos = first, pm.RawRowView(first)
+	} else {
+		ret.t, ret.pos = last, pm.RawRowView(last)
+	}
+	if t1 > t0 {
+		elapsed := float64(t1-t0) * dt
+		for k := 0; k < 3; k++ {
+			ret.vel[k] = (pm.At(t1, k) - pm.At(t0, k)) / elapsed
+		}
+	}
+	return ret
+}
+
+// mergeFragments joins the fragments of chain in time order,
+// interpolating screen plots linearly over the gaps between them.
+func mergeFragments(srList []vannotate.Series, chain []int) vannotate.Series {
+	first, last := srList[chain[0]], srList[chain[len(chain)-1]]
+	ret := vannotate.Series{
+		Start:     first.Start,
+		End:       last.End,
+		Plots:     make([]vannotate.ScreenPlot, len(first.Plots)),
+		Fragments: make([]int, 0, len(chain)),
+	}
+	copy(ret.Plots, first.Plots)
+
+	weight := .0
+	for k, idx := range chain {
+		sr := srList[idx]
+		copy(ret.Plots[sr.Start:sr.End+1], sr.Plots[sr.Start:sr.End+1])
+		if k > 0 {
+			prev := srList[chain[k-1]]
+			p0, p1 := prev.Plots[prev.End], sr.Plots[sr.Start]
+			for t := prev.End + 1; t < sr.Start; t++ {
+				r := float64(t-prev.End) / float64(sr.Start-prev.End)
+				ret.Plots[t] = vannotate.ScreenPlot{
+					P: (1-r)*p0.P + r*p1.P,
+					Q: (1-r)*p0.Q + r*p1.Q,
+				}
+			}
+		}
+
+		// Confidence weighted by length
+		length := float64(sr.End - sr.Start + 1)
+		ret.Conf += sr.Conf * float32(length)
+		ret.Attributes = mergeAttributes(ret.Attributes, weight, sr.Attributes, length)
+		weight += length
+		if len(sr.Fragments) > 0 {
+			ret.Fragments = append(ret.Fragments, sr.Fragments...)
+		} else {
+			ret.Fragments = append(ret.Fragments, idx)
+		}
+	}
+	ret.Conf /= float32(weight)
+	return ret
+}
+
+// mergeAttributes averages the confidences of each attribute value weighted by the lengths of the series.
+func mergeAttributes(a []vannotate.Attribute, wa float64, b []vannotate.Attribute, wb float64) []vannotate.Attribute {
+	type key struct{ name, value string }
+	sums := make(map[key]float64)
+	for _, attr := range a {
+		sums[key{attr.Name, attr.Value}] += float64(attr.Conf) * wa
+	}
+	for _, attr := range b {
+		sums[key{attr.Name, attr.Value}] += float64(attr.Conf) * wb
+	}
+	ret := make([]vannotate.Attribute, 0, len(sums))
+	for k, sum := range sums {
+		ret = append(ret, vannotate.Attribute{Name: k.name, Value: k.value, Conf: float32(sum / (wa + wb))})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return ret
+}
+
+// mergedAttributes merges the attributes of all the series.
+func mergedAttributes(srs []vannotate.Series) []vannotate.Attribute {
+	var ret []vannotate.Attribute
+	weight := .0
+	for _, sr := range srs {
+		length := float64(sr.End - sr.Start + 1)
+		ret = mergeAttributes(ret, weight, sr.Attributes, length)
+		weight += length
+	}
+	return ret
+}
+
+// attributeDistance compares the appearance descriptors of two sets of attributes,
+// or returns 0.5 when they have no attribute in common.
+func attributeDistance(a, b vannotate.Attributes) float64 {
+	if dist, ok := a.Appearance().Distance(b.Appearance()); ok {
+		return dist
+	}
+	return 0.5
+}
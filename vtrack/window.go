@@ -0,0 +1,165 @@
+package vtrack
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/payashi/vannotate"
+)
+
+type WindowConfig struct {
+	Length  int // frames per window
+	Overlap int // frames shared by consecutive windows
+}
+
+// Matches found within one window
+type WindowResult struct {
+	Start      int      `json:"start"`
+	End        int      `json:"end"`
+	IPlots     []IPlots `json:"iplots"`     // cropped to the window
+	Identities []int    `json:"identities"` // identity of each of IPlots, carried over from earlier windows
+}
+
+func (cs CameraSystem) IdenitfyWindows(ctx context.Context, srList1, srList2 []vannotate.Series, wconfig WindowConfig, cons Constraints, emit func(WindowResult)) error {
+	return identifyWindows(ctx, cs, srList1, srList2, wconfig, cons, emit)
+}
+
+func (hs HomographySystem) IdenitfyWindows(ctx context.Context, srList1, srList2 []vannotate.Series, wconfig WindowConfig, cons Constraints, emit func(WindowResult)) error {
+	return identifyWindows(ctx, hs, srList1, srList2, wconfig, cons, emit)
+}
+
+// identifyWindows matches series within fixed-length overlapping windows and
+// passes the result of each window to emit as soon as it is done.
+// Only the pairs of series active in the current window are integrated at a time,
+// which bounds the work and the memory per window. The series themselves are not streamed:
+// srList1 and srList2 are held whole, so memory still grows with the length of the session.
+// Series may have plots of different lengths, such as those of videos of different lengths.
+// A match keeps the identity of a series it shares with a match of an earlier window,
+// and identities are forgotten once all of their series have ended.
+// Must-links hold only in the windows where both of their series are seen together.
+// Windows emitted before ctx is done or an error stay valid.
+func identifyWindows(ctx context.Context, m CameraModel, srList1, srList2 []vannotate.Series, wconfig WindowConfig, cons Constraints, emit func(WindowResult)) error {
+	step := wconfig.Length - wconfig.Overlap
+	if wconfig.Length <= 0 || step <= 0 {
+		return fmt.Errorf("invalid window config %+v", wconfig)
+	}
+	srLists := [2][]vannotate.Series{srList1, srList2}
+	first, last := -1, -1
+	for _, srList := range srLists {
+		for _, sr := range srList {
+			if first == -1 || sr.Start < first {
+				first = sr.Start
+			}
+			last = maxInt(last, sr.End)
+		}
+	}
+	if first == -1 {
+		return nil
+	}
+
+	// Identity of each series matched so far
+	idents := [2]map[int]int{{}, {}}
+	next := 0
+	for start := first; ; start += step {
+		end := minInt(start+wconfig.Length-1, last)
+
+		// Series active in the window, cropped to it
+		var crops [2][]vannotate.Series
+		var origs [2][]int
+		for cami, srList := range srLists {
+			for idx, sr := range srList {
+				if sr.End < start || sr.Start > end {
+					continue
+				}
+				crops[cami] = append(crops[cami], vannotate.Series{
+					Conf:       sr.Conf,
+					Start:      maxInt(sr.Start, start) - start,
+					End:        minInt(sr.End, end) - start,
+					Plots:      sr.Plots[start : minInt(end, len(sr.Plots)-1)+1],
+					Attributes: sr.Attributes,
+					// Constraints refer to the series by these
+					Fragments: seriesIDs(sr, idx),
+				})
+				origs[cami] = append(origs[cami], idx)
+			}
+		}
+
+		ret := WindowResult{Start: start, End: end}
+		ips, err := identify(ctx, m, crops[0], crops[1], cons.within(crops[0], crops[1]))
+		if err != nil {
+			return err
+		}
+		ret.IPlots = ips
+		ret.Identities = make([]int, len(ret.IPlots))
+		used := make(map[int]bool)
+		for k := range ret.IPlots {
+			ip := &ret.IPlots[k]
+			ip.Start += start
+			ip.End += start
+			ip.i, ip.j = origs[0][ip.i], origs[1][ip.j]
+			ip.Series1, ip.Series2 = []int{ip.i}, []int{ip.j}
+			if ip.RunnerUp != nil {
+				ip.RunnerUp = &Alternative{
+					Series1:    []int{origs[0][ip.RunnerUp.Series1[0]]},
+					Series2:    []int{origs[1][ip.RunnerUp.Series2[0]]},
+					Fragments1: ip.RunnerUp.Fragments1,
+					Fragments2: ip.RunnerUp.Fragments2,
+					Cost:       ip.RunnerUp.Cost,
+				}
+			}
+
+			// Best matches pick their identities first
+			ident := -1
+			for cami, idx := range []int{ip.i, ip.j} {
+				if id, ok := idents[cami][idx]; ok && !used[id] && ident == -1 {
+					ident = id
+				}
+			}
+			if ident == -1 {
+				ident = next
+				next++
+			}
+			used[ident] = true
+			ret.Identities[k] = ident
+		}
+		for k, ip := range ret.IPlots {
+			idents[0][ip.i] = ret.Identities[k]
+			idents[1][ip.j] = ret.Identities[k]
+		}
+		emit(ret)
+
+		if end == last {
+			return nil
+		}
+		// Forget series which do not reach the next window
+		for cami, srList := range srLists {
+			for idx := range idents[cami] {
+				if srList[idx].End < start+step {
+					delete(idents[cami], idx)
+				}
+			}
+		}
+	}
+}
+
+// within drops the must-links of c whose series are not seen together in srList1 and srList2.
+func (c Constraints) within(srList1, srList2 []vannotate.Series) Constraints {
+	seen := func(srList []vannotate.Series, id int) (int, int, bool) {
+		for idx, sr := range srList {
+			if contains(seriesIDs(sr, idx), id) {
+				return sr.Start, sr.End, true
+			}
+		}
+		return 0, 0, false
+	}
+	ret := c
+	ret.MustLink = make([]Link, 0, len(c.MustLink))
+	for _, l := range c.MustLink {
+		start1, end1, ok1 := seen(srList1, l.Series1)
+		start2, end2, ok2 := seen(srList2, l.Series2)
+		if ok1 && ok2 && maxInt(start1, start2) <= minInt(end1, end2) {
+			ret.MustLink = append(ret.MustLink, l)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,48 @@
+package vtrack
+
+import (
+	"context"
+	"testing"
+
+	"github.com/payashi/vannotate"
+)
+
+// Videos of the two cameras may differ in length
+func TestIdentifyWindowsUnequalLengths(t *testing.T) {
+	srLists := synthSeries(4, 801, 4)
+	for k := range srLists[0] {
+		sr := &srLists[0][k]
+		sr.Plots = append([]vannotate.ScreenPlot(nil), sr.Plots[:601]...)
+		sr.End = minInt(sr.End, 600)
+	}
+	hs := synthHomographySystem()
+	var windows []WindowResult
+	err := hs.IdenitfyWindows(context.Background(), srLists[0], srLists[1], WindowConfig{Length: 200, Overlap: 50}, Constraints{},
+		func(w WindowResult) { windows = append(windows, w) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) == 0 || windows[len(windows)-1].End != maxEnd(srLists[1]) {
+		t.Fatalf("windows do not reach the end of camera2: %d windows", len(windows))
+	}
+	matched := false
+	for _, w := range windows {
+		for _, ip := range w.IPlots {
+			matched = true
+			if ip.Series1[0] != ip.Series2[0] {
+				t.Errorf("window %d-%d matched series %v with %v", w.Start, w.End, ip.Series1, ip.Series2)
+			}
+		}
+	}
+	if !matched {
+		t.Error("no matches in any window")
+	}
+}
+
+func maxEnd(srList []vannotate.Series) int {
+	ret := -1
+	for _, sr := range srList {
+		ret = maxInt(ret, sr.End)
+	}
+	return ret
+}